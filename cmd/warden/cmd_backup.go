@@ -2,35 +2,78 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/signal"
-	"syscall"
 
+	"github.com/alecthomas/units"
+
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/exclude"
+	"github.com/julianstephens/warden/internal/store"
 	"github.com/julianstephens/warden/internal/warden"
 )
 
 type BackupCmd struct {
 	CommonFlags
-	Dir    string `arg:"" type:"existingDir" help:"Path to the directory to backup"`
-	DryRun bool   `short:"d" help:"Print backup results with no write."`
+	LockFlags
+	Dir         string           `arg:"" optional:"" type:"existingdir" help:"Path to the directory to backup"`
+	Stdin       bool             `help:"Back up data read from stdin instead of a directory"`
+	StdinName   string           `name:"stdin-filename" default:"stdin" help:"File name of the data read with --stdin"`
+	DryRun      bool             `short:"d" help:"Print backup results with no write."`
+	FileWorkers int              `help:"Number of files chunked in parallel." default:"${defaultFileWorkers}"`
+	BlobWorkers int              `help:"Number of chunks compressed and encrypted in parallel." default:"${defaultBlobWorkers}"`
+	Uploaders   int              `help:"Number of packs uploaded in parallel." default:"${defaultUploaders}"`
+	MaxMemory   units.Base2Bytes `help:"Maximum chunk data buffered in memory." default:"${defaultMaxMemory}"`
+	Tag         []string         `help:"Tag the snapshot (repeatable)"`
+
+	Exclude          []string `short:"e" sep:"none" help:"Skip paths matching a gitignore style pattern (repeatable)"`
+	IExclude         []string `name:"iexclude" sep:"none" help:"Like --exclude but ignores case (repeatable)"`
+	ExcludeFile      []string `type:"existingfile" sep:"none" help:"Read exclude patterns from a file (repeatable)"`
+	ExcludeCaches    bool     `help:"Skip directories containing a CACHEDIR.TAG file"`
+	ExcludeIfPresent []string `sep:"none" help:"Skip directories containing a file with this name (repeatable)"`
+	OneFileSystem    bool     `short:"x" help:"Do not cross file system boundaries"`
 }
 
 func (c *BackupCmd) Run(ctx context.Context, globals *Globals) error {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, os.Interrupt, syscall.SIGINT)
-	defer func() {
-		fmt.Println()
-		warden.Printf("Ctrl/Cmd+C again to quit...")
-		<-sigs
-		fmt.Println("Interrupted. Stopping.")
-		os.Exit(warden.ExitCodeInterrupt)
-	}()
-
-	// TODO: do backup
-
-	return nil
+	warden.Log.Debug().Msg("BackupCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	if c.Stdin == (c.Dir != "") {
+		return errors.New("expected either a directory or --stdin")
+	}
+	// a password prompt would read from the data on stdin
+	if _, ok := c.passwordSource("").(*crypto.Prompt); ok && c.Stdin {
+		return fmt.Errorf("--stdin needs a password from --password-file, --password-command, --password-fd or %s", crypto.PasswordEnv)
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.LockRetry = c.lockRetry()
+
+	opts := store.BackupOptions{
+		FileWorkers: c.FileWorkers,
+		BlobWorkers: c.BlobWorkers,
+		Uploaders:   c.Uploaders,
+		MaxMemory:   int64(c.MaxMemory),
+		Tags:        c.Tag,
+		Exclude: exclude.Options{
+			Patterns:           c.Exclude,
+			IgnoreCasePatterns: c.IExclude,
+			PatternFiles:       c.ExcludeFile,
+			ExcludeCaches:      c.ExcludeCaches,
+			IfPresent:          c.ExcludeIfPresent,
+			OneFileSystem:      c.OneFileSystem,
+		},
+	}
+
+	if c.Stdin {
+		return s.BackupReader(ctx, os.Stdin, c.StdinName, opts)
+	}
+
+	return s.Backup(ctx, c.Dir, opts)
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type CheckCmd struct {
+	CommonFlags
+	ReadData       bool   `xor:"readdata" help:"Decrypt every blob and verify its HMAC"`
+	ReadDataSubset string `xor:"readdata" placeholder:"N%" help:"Decrypt and verify the blobs of a random N% of packs"`
+}
+
+func (c *CheckCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("CheckCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	opts := store.CheckOptions{ReadData: c.ReadData}
+	if c.ReadDataSubset != "" {
+		subset, err := parsePercent(c.ReadDataSubset)
+		if err != nil {
+			return fmt.Errorf("invalid --read-data-subset: %+v", err)
+		}
+		if subset == 0 {
+			return fmt.Errorf("invalid --read-data-subset: %s selects no packs", c.ReadDataSubset)
+		}
+		opts.ReadDataSubset = subset
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	result, err := s.Check(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range result.Errors {
+		fmt.Printf("error: %s\n", e)
+	}
+	for _, pack := range result.Unreferenced {
+		fmt.Printf("pack %s is not referenced by the index\n", pack)
+	}
+
+	fmt.Printf("checked %d keys, %d index files, %d packs with %d blobs and %d snapshots\n",
+		result.Keys, result.IndexFiles, result.Packs, result.Blobs, result.Snapshots)
+	if opts.ReadData || opts.ReadDataSubset > 0 {
+		fmt.Printf("read %d packs and verified %d blobs\n", result.PacksRead, result.BlobsRead)
+	}
+
+	if err = result.Err(); err != nil {
+		return err
+	}
+	fmt.Println("no errors found")
+
+	return nil
+}
+
+// parsePercent parses a percentage such as "10%" into a fraction
+func parsePercent(s string) (float64, error) {
+	n, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > 100 {
+		return 0, fmt.Errorf("%s is not between 0 and 100%%", s)
+	}
+
+	return n / 100, nil
+}
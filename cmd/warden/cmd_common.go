@@ -1,6 +1,86 @@
 package main
 
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/julianstephens/warden/internal/backend"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/store"
+)
+
 type CommonFlags struct {
 	Store     string `short:"s" xor:"storefile" required:"" type:"existingdir" help:"Path to your store"`
 	StoreFile string `short:"f" xor:"store" required:"" type:"existingfile" help:"Path to your store definition file"`
+	PasswordFlags
+}
+
+// PasswordFlags select a non-interactive password source. Without them the
+// WARDEN_PASSWORD environment variable is used, then a prompt.
+type PasswordFlags struct {
+	PasswordFile    string `type:"existingfile" xor:"password" help:"Read the store password from the first line of a file"`
+	PasswordCommand string `xor:"password" help:"Read the store password from the output of a command"`
+	PasswordFd      *int   `name:"password-fd" xor:"password" help:"Read the store password from an inherited file descriptor"`
+}
+
+// LockFlags configure commands that lock the store
+type LockFlags struct {
+	RetryLock time.Duration `placeholder:"DURATION" default:"${defaultRetryLock}" help:"How long to retry while the store is locked, 0 to fail at once"`
+}
+
+// lockRetry returns the default backoff limited to --retry-lock
+func (f LockFlags) lockRetry() store.LockRetry {
+	retry := store.DefaultLockRetry
+	retry.Timeout = f.RetryLock
+	return retry
+}
+
+func (f PasswordFlags) passwordSource(prompt string) crypto.PasswordSource {
+	return crypto.NewPasswordSource(crypto.PasswordOptions{
+		File:    f.PasswordFile,
+		Command: f.PasswordCommand,
+		FD:      f.PasswordFd,
+		Prompt:  prompt,
+	})
+}
+
+func openStore(ctx context.Context, flags CommonFlags) (*store.Store, error) {
+	return openStoreWith(ctx, flags, flags.passwordSource("Enter store password: "))
+}
+
+func openStoreWith(ctx context.Context, flags CommonFlags, pwd crypto.PasswordSource) (*store.Store, error) {
+	if flags.Store != "" {
+		return store.OpenStore(ctx, flags.Store, pwd)
+	}
+
+	def, err := backend.LoadDefinition(flags.StoreFile)
+	if err != nil {
+		return nil, err
+	}
+
+	be, loc, err := def.Open(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to initialize %s backend: %+v", def.Type, err)
+	}
+
+	s, err := store.Open(ctx, be, loc, pwd)
+	if err != nil {
+		be.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// readNewPassword asks for a new password twice, reusing the store password
+// prompt so both are read from the same input
+func readNewPassword(pwd crypto.PasswordSource) (string, error) {
+	prompt, ok := pwd.(*crypto.Prompt)
+	if !ok {
+		prompt = crypto.NewPrompt("")
+	}
+	prompt.Message = "Enter new password: "
+
+	return prompt.ReadPassword(true)
 }
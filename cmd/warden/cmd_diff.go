@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type DiffCmd struct {
+	CommonFlags
+	From string `arg:"" help:"ID or unique ID prefix of the older snapshot, or latest"`
+	To   string `arg:"" help:"ID or unique ID prefix of the newer snapshot, or latest"`
+	JSON bool   `name:"json" help:"Print JSON instead of a list of changes"`
+}
+
+// changeSummary is the JSON form of a change
+type changeSummary struct {
+	Kind store.ChangeKind      `json:"kind"`
+	Path string                `json:"path"`
+	Old  *storage.PathMetadata `json:"old,omitempty"`
+	New  *storage.PathMetadata `json:"new,omitempty"`
+}
+
+type diffSummary struct {
+	From            string          `json:"from"`
+	To              string          `json:"to"`
+	Changes         []changeSummary `json:"changes"`
+	Added           int             `json:"added"`
+	Removed         int             `json:"removed"`
+	Modified        int             `json:"modified"`
+	MetadataChanged int             `json:"metadataChanged"`
+	NewChunks       int             `json:"newChunks"`
+	NewSize         int64           `json:"newSize"`
+}
+
+// changeMarks prefix each path in the human readable output
+var changeMarks = map[store.ChangeKind]string{
+	store.ChangeAdded:    "+",
+	store.ChangeRemoved:  "-",
+	store.ChangeModified: "M",
+	store.ChangeMetadata: "U",
+}
+
+func (c *DiffCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("DiffCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	result, err := s.Diff(ctx, c.From, c.To)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		summary := diffSummary{
+			From:            result.From,
+			To:              result.To,
+			Changes:         make([]changeSummary, 0, len(result.Changes)),
+			Added:           result.Added,
+			Removed:         result.Removed,
+			Modified:        result.Modified,
+			MetadataChanged: result.MetadataChanged,
+			NewChunks:       result.NewChunks,
+			NewSize:         result.NewSize,
+		}
+		for _, change := range result.Changes {
+			summary.Changes = append(summary.Changes, changeSummary{Kind: change.Kind, Path: change.Path, Old: change.Old, New: change.New})
+		}
+		_, err = warden.PPrint(summary)
+		return err
+	}
+
+	fmt.Printf("comparing snapshot %s to %s\n", result.From[:8], result.To[:8])
+	for _, change := range result.Changes {
+		node := change.New
+		if node == nil {
+			node = change.Old
+		}
+		fmt.Printf("%s %s\n", changeMarks[change.Kind], nodeName(*node))
+	}
+
+	fmt.Println()
+	fmt.Printf("%d added, %d removed, %d modified, %d with changed metadata\n",
+		result.Added, result.Removed, result.Modified, result.MetadataChanged)
+	fmt.Printf("%d new chunks (%s) added to the store\n", result.NewChunks, formatSize(result.NewSize))
+
+	return nil
+}
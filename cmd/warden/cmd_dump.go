@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/term"
+
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type DumpCmd struct {
+	CommonFlags
+	Snapshot string `arg:"" help:"ID or unique ID prefix of the snapshot to dump, or latest"`
+	Path     string `arg:"" optional:"" help:"Directory or file in the snapshot to dump, relative to the backup dir. Defaults to the whole snapshot."`
+	Format   string `enum:"tar,zip" default:"tar" help:"Archive format (tar, zip)"`
+	Output   string `short:"o" type:"path" help:"Write the archive to a file instead of stdout"`
+}
+
+func (c *DumpCmd) Run(ctx context.Context, globals *Globals) (err error) {
+	warden.Log.Debug().Msg("DumpCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	var out io.Writer = os.Stdout
+	if c.Output == "" {
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			return errors.New("refusing to write an archive to a terminal, redirect stdout or use --output")
+		}
+		// the archive owns stdout, so debug output moves to stderr
+		if globals.Debug {
+			warden.SetLog(warden.NewLog(os.Stderr, zerolog.DebugLevel, time.RFC3339))
+		}
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	if c.Output != "" {
+		var f *os.File
+		f, err = os.OpenFile(c.Output, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %+v", c.Output, err)
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(c.Output)
+			}
+		}()
+		out = f
+	}
+
+	buf := bufio.NewWriter(out)
+	err = s.Dump(ctx, c.Snapshot, c.Path, store.DumpFormat(c.Format), buf)
+	if err != nil {
+		return err
+	}
+
+	return buf.Flush()
+}
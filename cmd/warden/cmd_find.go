@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alecthomas/units"
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type FindCmd struct {
+	CommonFlags
+	Pattern    string           `arg:"" help:"Glob pattern matched against file names, or against whole paths if it contains a slash"`
+	IgnoreCase bool             `short:"i" help:"Match the pattern case insensitively"`
+	Snapshot   []string         `help:"Only search this snapshot, an ID, unique ID prefix or latest (repeatable)"`
+	Host       []string         `help:"Only search snapshots of this host (repeatable)"`
+	Path       []string         `help:"Only search snapshots of this backup dir (repeatable)"`
+	Tag        []string         `help:"Only search snapshots with this tag (repeatable)"`
+	Newer      string           `placeholder:"TIME" help:"Only report files modified since TIME, a date, a date and time, or a duration ago such as 2w"`
+	Older      string           `placeholder:"TIME" help:"Only report files modified until TIME, a date, a date and time, or a duration ago such as 2w"`
+	MinSize    units.Base2Bytes `placeholder:"SIZE" help:"Only report files of at least SIZE"`
+	MaxSize    units.Base2Bytes `placeholder:"SIZE" help:"Only report files of at most SIZE"`
+	JSON       bool             `name:"json" help:"Print JSON instead of a table"`
+}
+
+// findResult is the JSON form of a match
+type findResult struct {
+	Snapshot  string               `json:"snapshot"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Hostname  string               `json:"hostname"`
+	Node      storage.PathMetadata `json:"node"`
+}
+
+func (c *FindCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("FindCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	paths, err := absPaths(c.Path)
+	if err != nil {
+		return err
+	}
+
+	opts := store.FindOptions{
+		Pattern:    c.Pattern,
+		IgnoreCase: c.IgnoreCase,
+		Snapshots:  c.Snapshot,
+		Filter:     store.SnapshotFilter{Hosts: c.Host, Paths: paths, Tags: c.Tag},
+		MinSize:    int64(c.MinSize),
+		MaxSize:    int64(c.MaxSize),
+	}
+	now := time.Now()
+	if c.Newer != "" {
+		opts.NewerThan, err = parseTime(c.Newer, now, false)
+		if err != nil {
+			return fmt.Errorf("invalid --newer: %+v", err)
+		}
+	}
+	if c.Older != "" {
+		opts.OlderThan, err = parseTime(c.Older, now, true)
+		if err != nil {
+			return fmt.Errorf("invalid --older: %+v", err)
+		}
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	matches, err := s.Find(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		results := make([]findResult, 0, len(matches))
+		for _, m := range matches {
+			results = append(results, findResult{Snapshot: m.Snapshot.ID, CreatedAt: m.Snapshot.CreatedAt, Hostname: m.Snapshot.Hostname, Node: m.Node})
+		}
+		_, err = warden.PPrint(results)
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Snapshot", "Time", "Host", "Mode", "User", "Group", "Size", "Modified", "Path"})
+	for _, m := range matches {
+		row := table.Row{m.Snapshot.ID[:8], m.Snapshot.CreatedAt.Local().Format(time.DateTime), m.Snapshot.Hostname}
+		t.AppendRow(append(row, nodeRow(m.Node)...))
+	}
+	t.Render()
+	fmt.Printf("%d matches\n", len(matches))
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type ForgetCmd struct {
+	CommonFlags
+	LockFlags
+	KeepLast    int      `placeholder:"N" help:"Keep the N most recent snapshots"`
+	KeepHourly  int      `placeholder:"N" help:"Keep the most recent snapshot of the last N hours with snapshots"`
+	KeepDaily   int      `placeholder:"N" help:"Keep the most recent snapshot of the last N days with snapshots"`
+	KeepWeekly  int      `placeholder:"N" help:"Keep the most recent snapshot of the last N weeks with snapshots"`
+	KeepMonthly int      `placeholder:"N" help:"Keep the most recent snapshot of the last N months with snapshots"`
+	KeepYearly  int      `placeholder:"N" help:"Keep the most recent snapshot of the last N years with snapshots"`
+	KeepWithin  string   `placeholder:"DURATION" help:"Keep snapshots taken within a duration of the newest, e.g. 1y2m3d4h or 2w"`
+	KeepTag     []string `help:"Keep snapshots with this tag (repeatable)"`
+	GroupBy     []string `enum:"host,path" default:"host,path" help:"Apply the policy separately to the snapshots of each host and/or path"`
+	DryRun      bool     `short:"n" help:"Print which snapshots would be removed without removing them"`
+}
+
+func (c *ForgetCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("ForgetCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	opts := store.ForgetOptions{
+		Policy: store.RetentionPolicy{
+			Last:    c.KeepLast,
+			Hourly:  c.KeepHourly,
+			Daily:   c.KeepDaily,
+			Weekly:  c.KeepWeekly,
+			Monthly: c.KeepMonthly,
+			Yearly:  c.KeepYearly,
+			Tags:    c.KeepTag,
+		},
+		DryRun: c.DryRun,
+	}
+	if c.KeepWithin != "" {
+		within, err := store.ParseDuration(c.KeepWithin)
+		if err != nil {
+			return fmt.Errorf("invalid --keep-within: %w", err)
+		}
+		opts.Policy.Within = within
+	}
+	for _, g := range c.GroupBy {
+		switch g {
+		case "host":
+			opts.GroupBy.Host = true
+		case "path":
+			opts.GroupBy.Path = true
+		}
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.LockRetry = c.lockRetry()
+
+	groups, err := s.Forget(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, g := range groups {
+		var scope []string
+		if opts.GroupBy.Host {
+			scope = append(scope, "host "+g.Host)
+		}
+		if opts.GroupBy.Path {
+			scope = append(scope, "path "+g.Path)
+		}
+		if len(scope) > 0 {
+			fmt.Printf("snapshots for %s\n", strings.Join(scope, ", "))
+		}
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"ID", "Time", "Host", "Path", "Tags", "Action", "Reasons"})
+		for _, d := range g.Decisions {
+			action := "keep"
+			if !d.Keep {
+				action = "remove"
+				removed++
+			}
+
+			snap := d.Snapshot
+			t.AppendRow(table.Row{snap.ID[:8], snap.CreatedAt.Local().Format(time.DateTime), snap.Hostname, snap.BackupVolume,
+				strings.Join(snap.Tags, ","), action, strings.Join(d.Reasons, ", ")})
+		}
+		t.Render()
+		fmt.Println()
+	}
+
+	if c.DryRun {
+		fmt.Printf("would remove %d snapshots\n", removed)
+	} else {
+		fmt.Printf("removed %d snapshots, run prune to free their data\n", removed)
+	}
+
+	return nil
+}
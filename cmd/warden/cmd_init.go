@@ -16,11 +16,18 @@ import (
 
 type InitCmd struct {
 	BackendType string         `required:"" short:"t" enum:"${backendTypes}" help:"The backend to create (${backendTypes})" default:"${defaultBackend}"`
-	Store       string         `short:"s" type:"path" help:"The location of the encrypted backup store"`
+	Store       string         `short:"s" type:"path" xor:"storefile" help:"The location of the encrypted backup store"`
+	StoreFile   string         `short:"f" type:"existingfile" xor:"store" help:"Path to a store definition file, used instead of --backend-type and --store"`
 	Params      map[string]int `help:"Argon2id params (t, m, p, T)" default:"${defaultParams}"`
+	Chunker     map[string]int `help:"Chunk sizes in bytes (min, avg, max)" default:"${defaultChunker}"`
+	Compression string         `short:"c" enum:"${compressionAlgorithms}" help:"Compression algorithm: none, s2 (fast) or zstd (strong)" default:"${defaultCompression}"`
+	Level       int            `short:"l" help:"Compression level (zstd: 1-22, s2: 1-9 with 1-2 default, 3-5 better, 6-9 best)" default:"${defaultCompressionLevel}"`
+	PasswordFlags
 }
 
 func (c *InitCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("InitCmd.Run")
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -34,6 +41,8 @@ func (c *InitCmd) Run(ctx context.Context, globals *Globals) error {
 		os.Exit(warden.ExitCodeInterrupt)
 	}()
 
+	ctx = warden.Log.WithContext(ctx)
+
 	t := common.BackendTypeStringMap[c.BackendType]
 	if t == common.BackendType(0) {
 		return fmt.Errorf("received invalid backend type: %+v", t)
@@ -47,27 +56,54 @@ func (c *InitCmd) Run(ctx context.Context, globals *Globals) error {
 		params.L = c.Params["T"]
 	}
 
-	password, err := crypto.ReadPassword()
+	var chunkerConf warden.ChunkerConfig
+	if c.Chunker != nil {
+		chunkerConf.MinSize = c.Chunker["min"]
+		chunkerConf.AvgSize = c.Chunker["avg"]
+		chunkerConf.MaxSize = c.Chunker["max"]
+	}
+
+	password, err := c.passwordSource("Enter password for new store: ").ReadPassword(true)
 	if err != nil {
 		return err
 	}
 
 	var be common.Backend
-	switch t {
-	case common.LocalStorage:
+	loc := c.Store
+	switch {
+	case c.StoreFile != "":
+		def, err := backend.LoadDefinition(c.StoreFile)
+		if err != nil {
+			return err
+		}
+
+		be, loc, err = def.Create(ctx)
+		if err != nil {
+			return fmt.Errorf("unable to initialize %s backend: %+v", def.Type, err)
+		}
+	case t == common.LocalStorage:
 		if c.Store == "" {
 			return fmt.Errorf("path to store must be provided for local storage backend type")
 		}
 
-		be, err = backend.NewBackend(t, common.LocalStorageParams{Location: c.Store})
+		be, err = backend.NewBackend(ctx, t, common.LocalStorageParams{Location: c.Store})
 		if err != nil {
 			return fmt.Errorf("unable to initialize localstorage backend: %+v", err)
 		}
+	default:
+		return fmt.Errorf("%s stores must be described by a store definition file", c.BackendType)
 	}
 
-	store := store.NewStore(be, c.Store)
+	s := store.NewStore(be, loc)
+	defer s.Close()
+
+	opts := store.InitOptions{
+		Params:      params,
+		Chunker:     chunkerConf,
+		Compression: warden.CompressionConfig{Algorithm: c.Compression, Level: c.Level},
+	}
 
-	err = store.Init(ctx, params, password)
+	err = s.Init(ctx, opts, password)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type KeyCmd struct {
+	List   KeyListCmd   `cmd:"" help:"List the keys of a store."`
+	Add    KeyAddCmd    `cmd:"" help:"Add a password that unlocks the same store."`
+	Remove KeyRemoveCmd `cmd:"" help:"Remove a key from a store."`
+	Passwd KeyPasswdCmd `cmd:"" help:"Change the password of the current key."`
+}
+
+type KeyListCmd struct {
+	CommonFlags
+}
+
+func (c *KeyListCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("KeyListCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"", "ID", "User", "Host", "Created", "KDF Params"})
+	for _, k := range keys {
+		current := ""
+		if k.ID() == s.Key().ID() {
+			current = "*"
+		}
+
+		t.AppendRow(table.Row{current, k.ID().String()[:8], k.Username, k.Hostname, k.CreatedAt.Local().Format(time.DateTime), k.Params.String()})
+	}
+	t.Render()
+
+	return nil
+}
+
+type KeyAddCmd struct {
+	CommonFlags
+}
+
+func (c *KeyAddCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("KeyAddCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	pwd := c.passwordSource("Enter store password: ")
+	s, err := openStoreWith(ctx, c.CommonFlags, pwd)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	password, err := readNewPassword(pwd)
+	if err != nil {
+		return err
+	}
+
+	k, err := s.AddPassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added key %s\n", k.ID().String()[:8])
+	return nil
+}
+
+type KeyRemoveCmd struct {
+	CommonFlags
+	LockFlags
+	ID string `arg:"" help:"ID or unique ID prefix of the key to remove"`
+}
+
+func (c *KeyRemoveCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("KeyRemoveCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.LockRetry = c.lockRetry()
+
+	err = s.RemoveKey(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Removed key %s\n", c.ID)
+	return nil
+}
+
+type KeyPasswdCmd struct {
+	CommonFlags
+	LockFlags
+}
+
+func (c *KeyPasswdCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("KeyPasswdCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	pwd := c.passwordSource("Enter store password: ")
+	s, err := openStoreWith(ctx, c.CommonFlags, pwd)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.LockRetry = c.lockRetry()
+
+	password, err := readNewPassword(pwd)
+	if err != nil {
+		return err
+	}
+
+	k, err := s.ChangePassword(ctx, password)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Password changed, new key is %s\n", k.ID().String()[:8])
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type LsCmd struct {
+	CommonFlags
+	Snapshot  string `arg:"" help:"ID or unique ID prefix of the snapshot to list, or latest"`
+	Path      string `arg:"" optional:"" help:"Directory or file in the snapshot to list, relative to the backup dir"`
+	Long      bool   `short:"l" help:"Print mode, owner, size and modification time"`
+	Recursive bool   `short:"r" help:"List subdirectories recursively"`
+	JSON      bool   `name:"json" help:"Print the metadata of each node as JSON"`
+}
+
+func (c *LsCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("LsCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	snap, err := s.FindSnapshot(ctx, c.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := store.ListNodes(*snap, c.Path, c.Recursive)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		if nodes == nil {
+			nodes = []storage.PathMetadata{}
+		}
+		_, err = warden.PPrint(nodes)
+		return err
+	}
+
+	if !c.Long {
+		for _, node := range nodes {
+			fmt.Println(nodeName(node))
+		}
+		return nil
+	}
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Mode", "User", "Group", "Size", "Modified", "Path"})
+	for _, node := range nodes {
+		t.AppendRow(nodeRow(node))
+	}
+	t.Render()
+
+	return nil
+}
+
+// nodeName returns the path of a node, marking directories with a trailing
+// slash and showing symlink targets
+func nodeName(node storage.PathMetadata) string {
+	switch node.Type {
+	case storage.NodeDir:
+		return node.Path + "/"
+	case storage.NodeSymlink:
+		return node.Path + " -> " + node.LinkTarget
+	default:
+		return node.Path
+	}
+}
+
+// nodeRow returns the long format columns of a node
+func nodeRow(node storage.PathMetadata) table.Row {
+	mode := "?"
+	if m, err := node.Mode(); err == nil {
+		mode = m.String()
+	}
+
+	user := node.User
+	if user == "" {
+		user = strconv.FormatUint(uint64(node.UID), 10)
+	}
+	group := node.Group
+	if group == "" {
+		group = strconv.FormatUint(uint64(node.GID), 10)
+	}
+
+	modified := ""
+	if !node.ModifiedAt.IsZero() {
+		modified = node.ModifiedAt.Local().Format(time.DateTime)
+	}
+
+	return table.Row{mode, user, group, formatSize(node.FileSize), modified, nodeName(node)}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alecthomas/units"
+
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type PruneCmd struct {
+	CommonFlags
+	LockFlags
+	MaxUnused     string           `placeholder:"N%" default:"10%" help:"Unused space, relative to used space, allowed to remain in partly used packs"`
+	MaxRepackSize units.Base2Bytes `placeholder:"SIZE" help:"Maximum size of packs to repack, unlimited by default"`
+	DryRun        bool             `short:"n" help:"Print what would be removed and repacked without changing the store"`
+}
+
+func (c *PruneCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("PruneCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	maxUnused, err := parsePercent(c.MaxUnused)
+	if err != nil {
+		return fmt.Errorf("invalid --max-unused: %+v", err)
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	s.LockRetry = c.lockRetry()
+
+	result, err := s.Prune(ctx, store.PruneOptions{
+		MaxUnused:     maxUnused,
+		MaxRepackSize: int64(c.MaxRepackSize),
+		DryRun:        c.DryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%d packs hold %d used blobs (%s) and %d unused blobs (%s)\n",
+		result.Packs, result.UsedBlobs, formatSize(result.UsedSize), result.UnusedBlobs, formatSize(result.UnusedSize))
+
+	if c.DryRun {
+		fmt.Printf("would remove %d unused packs\n", len(result.RemovedPacks))
+		fmt.Printf("would repack %d packs (%s) with %d used blobs\n",
+			len(result.RepackedPacks), formatSize(result.RepackedSize), result.RepackedBlobs)
+	} else {
+		fmt.Printf("removed %d unused packs\n", len(result.RemovedPacks))
+		fmt.Printf("repacked %d packs (%s) into %d new packs with %d blobs\n",
+			len(result.RepackedPacks), formatSize(result.RepackedSize), result.NewPacks, result.RepackedBlobs)
+	}
+	fmt.Printf("%s unused space remains\n", formatSize(result.RemainingUnused))
+
+	return nil
+}
+
+// formatSize prints a byte count with a binary unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type RestoreCmd struct {
+	CommonFlags
+	Snapshot string   `arg:"" help:"ID or unique ID prefix of the snapshot to restore, or latest"`
+	Target   string   `short:"t" required:"" type:"path" help:"Directory to restore files into"`
+	Include  []string `short:"i" help:"Only restore paths matching a glob pattern (repeatable)"`
+	Exclude  []string `short:"e" help:"Skip paths matching a glob pattern (repeatable)"`
+}
+
+func (c *RestoreCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("RestoreCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	return s.Restore(ctx, c.Snapshot, c.Target, store.RestoreOptions{Include: c.Include, Exclude: c.Exclude})
+}
@@ -3,9 +3,7 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 
-	"github.com/julianstephens/warden/internal/store"
 	"github.com/julianstephens/warden/internal/warden"
 )
 
@@ -26,29 +24,20 @@ func (c *ShowCmd) Run(ctx context.Context, globals *Globals) error {
 	}()
 
 	ctx = warden.Log.WithContext(ctx)
-	go show(ctx, &c.Store, &c.StoreFile, c.Resource, errChan)
+	go show(ctx, c.CommonFlags, c.Resource, errChan)
 
 	return <-errChan
 }
 
-func show(ctx context.Context, storeLoc *string, storeFile *string, resource string, errChan chan<- error) {
+func show(ctx context.Context, flags CommonFlags, resource string, errChan chan<- error) {
 Loop:
 	for {
-		var s *store.Store
-		var err error
-
-		if storeLoc != nil {
-			s, err = store.OpenStore(ctx, *storeLoc)
-			if err != nil {
-				errChan <- err
-				break
-			}
-		} else if storeFile != nil {
-			fmt.Println("got store file")
-		} else {
-			errChan <- errors.New("no store or store definition provided")
+		s, err := openStore(ctx, flags)
+		if err != nil {
+			errChan <- err
 			break
 		}
+		defer s.Close()
 
 		switch resource {
 		case "masterkey":
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type SnapshotsCmd struct {
+	CommonFlags
+	Host    []string `help:"Only list snapshots of this host (repeatable)"`
+	Path    []string `help:"Only list snapshots of this backup dir (repeatable)"`
+	Tag     []string `help:"Only list snapshots with this tag (repeatable)"`
+	Since   string   `placeholder:"TIME" help:"Only list snapshots created since TIME, a date, a date and time, or a duration ago such as 2w"`
+	Until   string   `placeholder:"TIME" help:"Only list snapshots created until TIME, a date, a date and time, or a duration ago such as 2w"`
+	GroupBy []string `enum:"host,path" default:"" help:"Group snapshots by host and/or path (host,path)"`
+	Latest  int      `placeholder:"N" help:"Only list the N most recent snapshots of each group"`
+	JSON    bool     `name:"json" help:"Print JSON instead of a table"`
+}
+
+// snapshotSummary is the JSON form of a listed snapshot
+type snapshotSummary struct {
+	ID        string    `json:"id"`
+	Parent    string    `json:"parent,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	Path      string    `json:"path"`
+	Tags      []string  `json:"tags"`
+	Files     int       `json:"files"`
+	Size      int64     `json:"size"`
+}
+
+type snapshotGroupSummary struct {
+	Host      string            `json:"host,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Snapshots []snapshotSummary `json:"snapshots"`
+}
+
+func (c *SnapshotsCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("SnapshotsCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	filter, err := c.filter(time.Now())
+	if err != nil {
+		return err
+	}
+
+	var by store.GroupBy
+	for _, g := range c.GroupBy {
+		switch g {
+		case "host":
+			by.Host = true
+		case "path":
+			by.Path = true
+		}
+	}
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	snaps, err := s.FindSnapshots(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	groups := store.GroupSnapshots(snaps, by)
+	if c.Latest > 0 {
+		for i := range groups {
+			groups[i] = groups[i].Latest(c.Latest)
+		}
+	}
+
+	if c.JSON {
+		return printSnapshotsJSON(groups, by)
+	}
+
+	total := 0
+	for _, g := range groups {
+		var scope []string
+		if by.Host {
+			scope = append(scope, "host "+g.Host)
+		}
+		if by.Path {
+			scope = append(scope, "path "+g.Path)
+		}
+		if len(scope) > 0 {
+			fmt.Printf("snapshots for %s\n", strings.Join(scope, ", "))
+		}
+
+		t := table.NewWriter()
+		t.SetOutputMirror(os.Stdout)
+		t.AppendHeader(table.Row{"ID", "Time", "Host", "User", "Path", "Tags", "Files", "Size"})
+		for _, snap := range g.Snapshots {
+			files, size := snap.Stats()
+			t.AppendRow(table.Row{snap.ID[:8], snap.CreatedAt.Local().Format(time.DateTime), snap.Hostname, snap.Username,
+				snap.BackupVolume, strings.Join(snap.Tags, ","), files, formatSize(size)})
+		}
+		t.Render()
+		fmt.Println()
+
+		total += len(g.Snapshots)
+	}
+	fmt.Printf("%d snapshots\n", total)
+
+	return nil
+}
+
+// filter builds the snapshot filter from the flags, resolving relative times
+// against now
+func (c *SnapshotsCmd) filter(now time.Time) (filter store.SnapshotFilter, err error) {
+	filter.Hosts = c.Host
+	filter.Tags = c.Tag
+	filter.Paths, err = absPaths(c.Path)
+	if err != nil {
+		return
+	}
+
+	if c.Since != "" {
+		filter.Since, err = parseTime(c.Since, now, false)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --since: %+v", err)
+		}
+	}
+	if c.Until != "" {
+		filter.Until, err = parseTime(c.Until, now, true)
+		if err != nil {
+			return filter, fmt.Errorf("invalid --until: %+v", err)
+		}
+	}
+
+	return
+}
+
+// absPaths resolves backup dirs given on the command line to the absolute
+// paths snapshots record. Volumes of data backed up from stdin are kept.
+func absPaths(paths []string) ([]string, error) {
+	var result []string
+	for _, p := range paths {
+		if strings.HasPrefix(p, store.StdinPrefix) {
+			result = append(result, p)
+			continue
+		}
+
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve path %s: %+v", p, err)
+		}
+		result = append(result, abs)
+	}
+
+	return result, nil
+}
+
+var timeLayouts = []string{time.DateTime, "2006-01-02 15:04", time.RFC3339}
+
+// parseTime parses a local date, date and time, RFC 3339 timestamp or a
+// duration before now. A date alone means the start of the day, or its end
+// if endOfDay is set.
+func parseTime(s string, now time.Time, endOfDay bool) (time.Time, error) {
+	if d, err := store.ParseDuration(s); err == nil {
+		return d.Before(now), nil
+	}
+
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		if endOfDay {
+			t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+		return t, nil
+	}
+
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is not a date, time or duration", s)
+}
+
+func printSnapshotsJSON(groups []store.SnapshotGroup, by store.GroupBy) error {
+	summaries := make([]snapshotGroupSummary, 0, len(groups))
+	for _, g := range groups {
+		group := snapshotGroupSummary{Host: g.Host, Path: g.Path, Snapshots: make([]snapshotSummary, 0, len(g.Snapshots))}
+		for _, snap := range g.Snapshots {
+			group.Snapshots = append(group.Snapshots, summarizeSnapshot(snap))
+		}
+		summaries = append(summaries, group)
+	}
+
+	if by.Host || by.Path {
+		_, err := warden.PPrint(summaries)
+		return err
+	}
+
+	// without grouping there is at most one group, printed as a plain list
+	flat := []snapshotSummary{}
+	for _, g := range summaries {
+		flat = append(flat, g.Snapshots...)
+	}
+	_, err := warden.PPrint(flat)
+	return err
+}
+
+func summarizeSnapshot(snap storage.Snapshot) snapshotSummary {
+	files, size := snap.Stats()
+	tags := snap.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
+	return snapshotSummary{
+		ID:        snap.ID,
+		Parent:    snap.Parent,
+		CreatedAt: snap.CreatedAt,
+		Hostname:  snap.Hostname,
+		Username:  snap.Username,
+		Path:      snap.BackupVolume,
+		Tags:      tags,
+		Files:     files,
+		Size:      size,
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type UnlockCmd struct {
+	CommonFlags
+	RemoveAll bool `help:"Remove every lock, not only stale ones. Only use this when no other warden process uses the store."`
+}
+
+func (c *UnlockCmd) Run(ctx context.Context, globals *Globals) error {
+	warden.Log.Debug().Msg("UnlockCmd.Run")
+
+	ctx = warden.Log.WithContext(ctx)
+
+	s, err := openStore(ctx, c.CommonFlags)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	removed, err := s.RemoveLocks(ctx, c.RemoveAll)
+	for _, lock := range removed {
+		fmt.Printf("removed %s\n", lock)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("removed %d locks\n", len(removed))
+	return nil
+}
@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/alecthomas/kong"
+	"github.com/alecthomas/units"
 	"github.com/rs/zerolog"
 
 	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/chunker"
+	"github.com/julianstephens/warden/internal/compress"
 	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/store"
 	"github.com/julianstephens/warden/internal/warden"
 )
 
@@ -23,9 +28,20 @@ type Globals struct {
 
 type CLI struct {
 	Globals
-	Init   InitCmd   `cmd:"" help:"Create a new encrypted backup store."`
-	Show   ShowCmd   `cmd:"" help:"Print resource information."`
-	Backup BackupCmd `cmd:"" help:"Create a new backup of a directory."`
+	Init      InitCmd      `cmd:"" help:"Create a new encrypted backup store."`
+	Show      ShowCmd      `cmd:"" help:"Print resource information."`
+	Backup    BackupCmd    `cmd:"" help:"Create a new backup of a directory."`
+	Restore   RestoreCmd   `cmd:"" help:"Restore files from a snapshot."`
+	Dump      DumpCmd      `cmd:"" help:"Write files from a snapshot as a tar or zip archive."`
+	Key       KeyCmd       `cmd:"" help:"Manage the passwords of a store."`
+	Check     CheckCmd     `cmd:"" help:"Verify the integrity of a store."`
+	Forget    ForgetCmd    `cmd:"" help:"Remove snapshots according to a retention policy."`
+	Prune     PruneCmd     `cmd:"" help:"Remove data no snapshot references."`
+	Unlock    UnlockCmd    `cmd:"" help:"Remove stale locks from a store."`
+	Snapshots SnapshotsCmd `cmd:"" help:"List the snapshots of a store."`
+	Ls        LsCmd        `cmd:"" help:"List the files of a snapshot."`
+	Find      FindCmd      `cmd:"" help:"Search snapshots for files."`
+	Diff      DiffCmd      `cmd:"" help:"Show the changes between two snapshots."`
 }
 
 type debugFlag bool
@@ -83,11 +99,20 @@ func createApp(ctx context.Context) (*kong.Context, CLI) {
 			Compact: true,
 		}),
 		kong.Vars{
-			"version":        Version,
-			"backendTypes":   strings.Join(common.BackendTypes, ","),
-			"defaultParams":  crypto.DefaultParams.String(),
-			"defaultBackend": common.LocalStorage.String(),
-			"resources":      strings.Join(common.Resources, ","),
+			"version":                 Version,
+			"backendTypes":            strings.Join(common.BackendTypes, ","),
+			"defaultParams":           crypto.DefaultParams.String(),
+			"defaultChunker":          fmt.Sprintf("min=%d;avg=%d;max=%d", chunker.DefaultOptions.MinSize, chunker.DefaultOptions.AverageSize, chunker.DefaultOptions.MaxSize),
+			"defaultBackend":          common.LocalStorage.String(),
+			"resources":               strings.Join(common.Resources, ","),
+			"compressionAlgorithms":   strings.Join(compress.Algorithms, ","),
+			"defaultCompression":      compress.DefaultAlgorithm.String(),
+			"defaultCompressionLevel": strconv.Itoa(compress.DefaultLevel),
+			"defaultFileWorkers":      strconv.Itoa(store.DefaultBackupOptions.FileWorkers),
+			"defaultBlobWorkers":      strconv.Itoa(store.DefaultBackupOptions.BlobWorkers),
+			"defaultUploaders":        strconv.Itoa(store.DefaultBackupOptions.Uploaders),
+			"defaultRetryLock":        store.DefaultLockRetry.Timeout.String(),
+			"defaultMaxMemory":        units.Base2Bytes(store.DefaultBackupOptions.MaxMemory).String(),
 		},
 		kong.Bind(ctx))
 	kongCtx.BindTo(ctx, (*context.Context)(nil))
@@ -3,7 +3,6 @@ module github.com/julianstephens/warden
 go 1.23.2
 
 require (
-	github.com/agiledragon/gomonkey/v2 v2.12.0
 	github.com/alecthomas/kong v1.2.1
 	github.com/alecthomas/units v0.0.0-20240927000941-0f3dac36c52b
 	github.com/jedib0t/go-pretty/v6 v6.6.1
@@ -13,7 +12,31 @@ require (
 	golang.org/x/term v0.25.0
 )
 
-require github.com/xhd2015/xgo/runtime v1.0.52
+require github.com/klauspost/compress v1.17.11
+
+require (
+	github.com/johannesboyne/gofakes3 v0.0.0-20250106100439-5c39aecd6999
+	github.com/minio/minio-go/v7 v7.0.80
+	github.com/pkg/sftp v1.13.7
+	golang.org/x/sync v0.8.0
+)
+
+require (
+	github.com/aws/aws-sdk-go v1.44.256 // indirect
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/go-ini/ini v1.67.0 // indirect
+	github.com/goccy/go-json v0.10.3 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.2.8 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/minio/md5-simd v1.1.2 // indirect
+	github.com/rs/xid v1.6.0 // indirect
+	github.com/ryszard/goskiplist v0.0.0-20150312221310-2dfbae5fcf46 // indirect
+	go.shabbyrobe.org/gocovmerge v0.0.0-20230507111327-fa4f82cfbf4d // indirect
+	golang.org/x/net v0.30.0 // indirect
+	golang.org/x/text v0.19.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
+)
 
 require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
@@ -21,5 +44,5 @@ require (
 	github.com/mattn/go-runewidth v0.0.15 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/rs/zerolog v1.33.0
-	golang.org/x/sys v0.27.0 // indirect
+	golang.org/x/sys v0.27.0
 )
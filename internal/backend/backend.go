@@ -1,17 +1,99 @@
 package backend
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
 
 	"github.com/julianstephens/warden/internal/backend/common"
 	"github.com/julianstephens/warden/internal/backend/local"
+	"github.com/julianstephens/warden/internal/backend/s3"
+	"github.com/julianstephens/warden/internal/backend/sftp"
 )
 
-func NewBackend(t common.BackendType, params common.Params) (common.Backend, error) {
+// Definition describes a store's backend, as read from a store definition file
+type Definition struct {
+	// Type is one of common.BackendTypes
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+}
+
+func NewBackend(ctx context.Context, t common.BackendType, params common.Params) (common.Backend, error) {
 	switch t {
 	case common.LocalStorage:
 		return local.NewLocalStorage(params.(common.LocalStorageParams))
+	case common.S3:
+		return s3.NewS3Storage(ctx, params.(common.S3StorageParams))
+	case common.SFTP:
+		return sftp.NewSFTPStorage(ctx, params.(common.SFTPStorageParams))
 	default:
 		return nil, fmt.Errorf("invalid backend type: %s", t.String())
 	}
 }
+
+// LoadDefinition reads a store definition file
+func LoadDefinition(filename string) (def Definition, err error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		err = fmt.Errorf("unable to read store definition: %+v", err)
+		return
+	}
+
+	err = json.Unmarshal(data, &def)
+	if err != nil {
+		err = fmt.Errorf("unable to parse store definition: %+v", err)
+	}
+
+	return
+}
+
+// Open creates the backend of a definition. The returned location describes
+// the store in logs and messages.
+func (d Definition) Open(ctx context.Context) (common.Backend, string, error) {
+	return d.open(ctx, false)
+}
+
+// Create is like Open but also creates missing S3 buckets, for init
+func (d Definition) Create(ctx context.Context) (common.Backend, string, error) {
+	return d.open(ctx, true)
+}
+
+func (d Definition) open(ctx context.Context, create bool) (be common.Backend, loc string, err error) {
+	t, ok := common.BackendTypeStringMap[d.Type]
+	if !ok {
+		err = fmt.Errorf("invalid backend type: %q", d.Type)
+		return
+	}
+
+	var params common.Params
+	switch t {
+	case common.LocalStorage:
+		p, e := parseParams[common.LocalStorageParams](d.Params)
+		params, loc, err = p, p.Location, e
+	case common.S3:
+		p, e := parseParams[common.S3StorageParams](d.Params)
+		p.Create = create
+		params, loc, err = p, fmt.Sprintf("s3:%s/%s/%s", p.Endpoint, p.Bucket, p.Prefix), e
+	case common.SFTP:
+		p, e := parseParams[common.SFTPStorageParams](d.Params)
+		params, loc, err = p, fmt.Sprintf("sftp:%s@%s:%s", p.User, p.Host, p.Path), e
+	default:
+		err = fmt.Errorf("store definitions do not support backend type %s", t.String())
+	}
+	if err != nil {
+		return
+	}
+
+	be, err = NewBackend(ctx, t, params)
+	return
+}
+
+func parseParams[T any](data json.RawMessage) (params T, err error) {
+	err = json.Unmarshal(data, &params)
+	if err != nil {
+		err = fmt.Errorf("invalid backend params: %+v", err)
+	}
+
+	return
+}
@@ -0,0 +1,179 @@
+// Package backendtest checks that a backend implements common.Backend
+package backendtest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io/fs"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+)
+
+// File is a file saved by Run
+type File struct {
+	Event common.Event
+	Data  []byte
+}
+
+// Files lists one file of every type. Run saves them to the backend.
+var Files = []File{
+	{common.Event{Type: common.Config}, []byte(`{"version":1}`)},
+	{common.Event{Type: common.Key, Name: ptr("k1")}, []byte("key")},
+	{common.Event{Type: common.Pack, Name: ptr("abcdef")}, []byte("pack data")},
+	{common.Event{Type: common.Index, Name: ptr("i1")}, []byte("index")},
+	{common.Event{Type: common.Snapshot, Name: ptr("s1")}, []byte("snapshot")},
+	{common.Event{Type: common.Lock, Name: ptr("l1")}, []byte("lock")},
+}
+
+// Run saves Files to an empty backend and checks they load, stat, list and
+// remove as the Backend interface documents
+func Run(t *testing.T, be common.Backend) {
+	ctx := context.Background()
+
+	for _, f := range Files {
+		exists, err := be.Exists(ctx, f.Event)
+		if err != nil || exists {
+			t.Fatalf("expected %s to not exist before saving, got %t, %+v", f.Event.Type, exists, err)
+		}
+
+		err = be.Save(ctx, f.Event, common.NewByteReader(f.Data))
+		if err != nil {
+			t.Fatalf("unable to save %s: %+v", f.Event.Type, err)
+		}
+
+		got, err := be.Load(ctx, f.Event, 0, 0)
+		if err != nil {
+			t.Fatalf("unable to load %s: %+v", f.Event.Type, err)
+		}
+		if !bytes.Equal(got, f.Data) {
+			t.Fatalf("expected %s content %q, got %q", f.Event.Type, f.Data, got)
+		}
+
+		info, err := be.Stat(ctx, f.Event)
+		if err != nil {
+			t.Fatalf("unable to stat %s: %+v", f.Event.Type, err)
+		}
+		if info.Size != int64(len(f.Data)) {
+			t.Fatalf("expected %s size %d, got %d", f.Event.Type, len(f.Data), info.Size)
+		}
+
+		exists, err = be.Exists(ctx, f.Event)
+		if err != nil || !exists {
+			t.Fatalf("expected %s to exist after saving, got %t, %+v", f.Event.Type, exists, err)
+		}
+	}
+
+	pack := Files[2]
+	ranges := []struct {
+		offset, length int64
+		want           string
+	}{
+		{0, 4, "pack"},
+		{5, 4, "data"},
+		{5, 0, "data"},
+	}
+	for _, r := range ranges {
+		got, err := be.Load(ctx, pack.Event, r.offset, r.length)
+		if err != nil {
+			t.Fatalf("unable to load range %d+%d: %+v", r.offset, r.length, err)
+		}
+		if string(got) != r.want {
+			t.Fatalf("expected range %d+%d to be %q, got %q", r.offset, r.length, r.want, got)
+		}
+	}
+
+	_, err := be.Load(ctx, pack.Event, 5, 100)
+	if err == nil {
+		t.Fatalf("expected loading past the end of a file to fail")
+	}
+
+	err = be.Save(ctx, pack.Event, common.NewByteReader([]byte("other")))
+	if err == nil {
+		t.Fatalf("expected saving an existing pack to conflict")
+	}
+
+	streamed := common.Event{Type: common.Snapshot, Name: ptr("streamed")}
+	content := bytes.Repeat([]byte("warden "), 1<<16)
+	sum := sha256.Sum256(content)
+
+	f, err := os.CreateTemp(t.TempDir(), "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reader is rewound before it is written
+	reader, err := common.NewFileReader(f, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = be.Save(ctx, streamed, reader)
+	if err != nil {
+		t.Fatalf("unable to save from a file: %+v", err)
+	}
+
+	got, err := be.Load(ctx, streamed, 0, 0)
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("expected streamed file to round trip, got %d bytes, %+v", len(got), err)
+	}
+
+	err = be.Remove(ctx, streamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := common.Event{Type: common.Snapshot, Name: ptr("corrupt")}
+	err = be.Save(ctx, corrupt, common.NewHashedByteReader([]byte("data"), sum[:]))
+	if err == nil {
+		t.Fatalf("expected saving content that does not match its hash to fail")
+	}
+
+	exists, err := be.Exists(ctx, corrupt)
+	if err != nil || exists {
+		t.Fatalf("expected a failed save to leave no file behind, got %t, %+v", exists, err)
+	}
+
+	lists := map[common.FileType]string{common.Key: "k1", common.Pack: "abcdef", common.Index: "i1", common.Snapshot: "s1", common.Lock: "l1"}
+	for ft, want := range lists {
+		names, err := be.List(ctx, ft)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(names, []string{want}) {
+			t.Fatalf("expected %s list [%s], got %v", ft, want, names)
+		}
+	}
+
+	key := Files[1]
+	err = be.Remove(ctx, key.Event)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = be.Load(ctx, key.Event, 0, 0)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected removed key to not exist, got %+v", err)
+	}
+
+	_, err = be.Stat(ctx, key.Event)
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected stat of removed key to not exist, got %+v", err)
+	}
+
+	names, err := be.List(ctx, common.Key)
+	if err != nil || len(names) != 0 {
+		t.Fatalf("expected no keys after removal, got %v, %+v", names, err)
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
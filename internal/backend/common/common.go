@@ -2,8 +2,6 @@ package common
 
 import (
 	"context"
-
-	"github.com/julianstephens/warden/internal/storage"
 )
 
 type BackendType int
@@ -32,11 +30,29 @@ var BackendTypes = func() []string {
 	return m
 }()
 
+// Backend stores the files of a store, addressed by file type and name. Files
+// that do not exist are reported with errors matching fs.ErrNotExist.
 type Backend interface {
 	// Save writes content to the specified backend
 	Save(ctx context.Context, event Event, reader IReader) error
-	// ListSnapshots retrieves all backup snapshots for a store
-	ListSnapshots(ctx context.Context) ([]storage.Snapshot, error)
+	// Load reads length bytes of a file starting at offset. A length of 0
+	// reads to the end of the file.
+	Load(ctx context.Context, event Event, offset int64, length int64) ([]byte, error)
+	// Stat returns the size of a file
+	Stat(ctx context.Context, event Event) (FileInfo, error)
+	// List retrieves the names of all files of a given type
+	List(ctx context.Context, t FileType) ([]string, error)
+	// Remove deletes a file from the backend
+	Remove(ctx context.Context, event Event) error
+	// Exists reports whether a file is stored in the backend
+	Exists(ctx context.Context, event Event) (bool, error)
+	// Close releases connections held by the backend
+	Close() error
+}
+
+type FileInfo struct {
+	Name string
+	Size int64
 }
 
 type WardenBackend struct {
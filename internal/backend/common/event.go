@@ -6,6 +6,9 @@ const (
 	Config FileType = 1 << iota
 	Key
 	Pack
+	Index
+	Snapshot
+	Lock
 )
 
 type Event struct {
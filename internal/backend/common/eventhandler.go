@@ -5,5 +5,8 @@ import "context"
 type EventHandler interface {
 	WriteConfig(ctx context.Context, reader IReader) error
 	WriteKey(ctx context.Context, filename string, reader IReader) error
-	WritePack(data []byte) error
+	WritePack(ctx context.Context, filename string, reader IReader) error
+	WriteIndex(ctx context.Context, filename string, reader IReader) error
+	WriteSnapshot(ctx context.Context, filename string, reader IReader) error
+	WriteLock(ctx context.Context, filename string, reader IReader) error
 }
@@ -11,11 +11,17 @@ func _() {
 	_ = x[Config-1]
 	_ = x[Key-2]
 	_ = x[Pack-4]
+	_ = x[Index-8]
+	_ = x[Snapshot-16]
+	_ = x[Lock-32]
 }
 
 const (
 	_FileType_name_0 = "ConfigKey"
 	_FileType_name_1 = "Pack"
+	_FileType_name_2 = "Index"
+	_FileType_name_3 = "Snapshot"
+	_FileType_name_4 = "Lock"
 )
 
 var (
@@ -29,6 +35,12 @@ func (i FileType) String() string {
 		return _FileType_name_0[_FileType_index_0[i]:_FileType_index_0[i+1]]
 	case i == 4:
 		return _FileType_name_1
+	case i == 8:
+		return _FileType_name_2
+	case i == 16:
+		return _FileType_name_3
+	case i == 32:
+		return _FileType_name_4
 	default:
 		return "FileType(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
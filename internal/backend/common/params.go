@@ -1,8 +1,57 @@
 package common
 
+import "net/http"
+
 type Params interface{}
 
 type LocalStorageParams struct {
 	Params
-	Location string
+	Location string `json:"location"`
+}
+
+// S3StorageParams configures a store in a bucket of an S3-compatible service
+type S3StorageParams struct {
+	Params
+	// Endpoint is the host and optional port of the service, without scheme
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	// Prefix places the store under a key prefix of the bucket
+	Prefix string `json:"prefix,omitempty"`
+	Region string `json:"region,omitempty"`
+	// AccessKey and SecretKey are read from the environment when unset
+	AccessKey    string `json:"accessKey,omitempty"`
+	SecretKey    string `json:"secretKey,omitempty"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// as most self-hosted services expect
+	PathStyle    bool   `json:"pathStyle,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	// Insecure connects over plain HTTP
+	Insecure bool `json:"insecure,omitempty"`
+	// PartSize is the multipart upload part size in bytes. Objects larger
+	// than a part are uploaded in parts.
+	PartSize uint64 `json:"partSize,omitempty"`
+	// Transport replaces the default HTTP transport, e.g. to trust a private
+	// certificate authority
+	Transport http.RoundTripper `json:"-"`
+	// Create makes the bucket if it does not exist, as init does. Otherwise a
+	// missing bucket is an error.
+	Create bool `json:"-"`
+}
+
+// SFTPStorageParams configures a store in a directory of an SFTP server
+type SFTPStorageParams struct {
+	Params
+	Host string `json:"host"`
+	// Port defaults to 22
+	Port int    `json:"port,omitempty"`
+	User string `json:"user"`
+	// Path is the store directory on the server
+	Path string `json:"path"`
+	// IdentityFile is an unencrypted private key. Keys held by the agent at
+	// SSH_AUTH_SOCK are offered as well.
+	IdentityFile string `json:"identityFile,omitempty"`
+	// KnownHostsFile verifies the server's host key and defaults to
+	// ~/.ssh/known_hosts
+	KnownHostsFile string `json:"knownHostsFile,omitempty"`
 }
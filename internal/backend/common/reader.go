@@ -2,18 +2,29 @@ package common
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
 	"io"
+	"os"
 )
 
+// IReader streams the content of a file to a backend
 type IReader interface {
+	io.Reader
+	// Length returns the number of bytes the reader yields
 	Length() int64
+	// Reset rewinds the reader to the start so a failed write can be retried
 	Reset() error
+	// Hash returns the SHA-256 of the content, or nil if it is not known up
+	// front. Backends verify written content against it.
+	Hash() []byte
 }
 
 type ByteReader struct {
 	*bytes.Reader
-	IReader
-	Len int64
+	Len  int64
+	hash []byte
 }
 
 func (b *ByteReader) Length() int64 {
@@ -25,9 +36,136 @@ func (b *ByteReader) Reset() error {
 	return err
 }
 
+func (b *ByteReader) Hash() []byte {
+	return b.hash
+}
+
 func NewByteReader(data []byte) *ByteReader {
 	return &ByteReader{
 		Reader: bytes.NewReader(data),
 		Len:    int64(len(data)),
 	}
 }
+
+// NewHashedByteReader creates a reader for data whose SHA-256 is already known
+func NewHashedByteReader(data []byte, sum []byte) *ByteReader {
+	r := NewByteReader(data)
+	r.hash = sum
+	return r
+}
+
+// SeekReader streams length bytes from the start of an io.ReadSeeker, such as
+// an open file, without holding them in memory
+type SeekReader struct {
+	io.ReadSeeker
+	Len  int64
+	hash []byte
+}
+
+func (s *SeekReader) Length() int64 {
+	return s.Len
+}
+
+func (s *SeekReader) Reset() error {
+	_, err := s.ReadSeeker.Seek(0, io.SeekStart)
+	return err
+}
+
+func (s *SeekReader) Hash() []byte {
+	return s.hash
+}
+
+// NewSeekReader creates a reader for length bytes of r. sum may be nil.
+func NewSeekReader(r io.ReadSeeker, length int64, sum []byte) *SeekReader {
+	return &SeekReader{ReadSeeker: r, Len: length, hash: sum}
+}
+
+// NewFileReader creates a reader for the full content of an open file
+func NewFileReader(f *os.File, sum []byte) (*SeekReader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %+v", f.Name(), err)
+	}
+
+	return NewSeekReader(f, info.Size(), sum), nil
+}
+
+// Verified rewinds reader and returns a stream of exactly its length. Reads
+// fail if the content is shorter; writers call Check once done to compare the
+// content with the reader's hash before committing it.
+func Verified(reader IReader) (*VerifiedReader, error) {
+	err := reader.Reset()
+	if err != nil {
+		return nil, fmt.Errorf("unable to rewind reader: %+v", err)
+	}
+
+	return &VerifiedReader{src: reader, hasher: sha256.New(), length: reader.Length(), hash: reader.Hash()}, nil
+}
+
+type VerifiedReader struct {
+	src    io.Reader
+	hasher hash.Hash
+	length int64
+	read   int64
+	hash   []byte
+}
+
+func (v *VerifiedReader) Read(p []byte) (int, error) {
+	remaining := v.length - v.read
+	if remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := v.src.Read(p)
+	v.hasher.Write(p[:n])
+	v.read += int64(n)
+
+	if err == io.EOF && v.read < v.length {
+		return n, v.Check()
+	}
+
+	return n, err
+}
+
+// Check reports whether the full content was read and matches the hash
+func (v *VerifiedReader) Check() error {
+	if v.read != v.length {
+		return fmt.Errorf("expected %d bytes, reader yielded %d", v.length, v.read)
+	}
+
+	if v.hash != nil && !bytes.Equal(v.hasher.Sum(nil), v.hash) {
+		return fmt.Errorf("content does not match its hash %x", v.hash)
+	}
+
+	return nil
+}
+
+// ReadRange reads length bytes of r starting at offset. A length of 0 reads to
+// the end.
+func ReadRange(r io.ReadSeeker, offset int64, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", offset, length)
+	}
+
+	if offset > 0 {
+		_, err := r.Seek(offset, io.SeekStart)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if length == 0 {
+		return io.ReadAll(r)
+	}
+
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %d bytes at offset %d: %w", length, offset, err)
+	}
+
+	return data, nil
+}
@@ -15,24 +15,18 @@ import (
 type LocalHandler struct{}
 
 var (
-	ErrInvalidByteReader = errors.New("invalid byte reader")
-	ErrNoStoreLocation   = errors.New("no store location provided")
+	ErrNoStoreLocation = errors.New("no store location provided")
 )
 
 func (h *LocalHandler) WriteConfig(ctx context.Context, reader common.IReader) error {
-	bReader, ok := reader.(*common.ByteReader)
-	if !ok {
-		return ErrInvalidByteReader
-	}
-
 	loc := getCtxLocation(ctx, LocationCtxKey("location"))
 	if loc == nil {
 		return ErrNoStoreLocation
 	}
 
-	filePath := path.Join(loc.(string), "config.json")
+	filePath := path.Join(loc.(string), configFile)
 	warden.Log.Debug().Msgf("writing %s", filePath)
-	err := writeBytes(filePath, bReader.Reader, bReader.Len)
+	err := writeBytes(filePath, reader)
 	if err != nil {
 		return err
 	}
@@ -42,24 +36,41 @@ func (h *LocalHandler) WriteConfig(ctx context.Context, reader common.IReader) e
 }
 
 func (h *LocalHandler) WriteKey(ctx context.Context, filename string, reader common.IReader) error {
-	bReader, ok := reader.(*common.ByteReader)
-	if !ok {
-		return ErrInvalidByteReader
-	}
+	return writeFile(ctx, keyDir, filename, reader)
+}
+
+func (h *LocalHandler) WritePack(ctx context.Context, filename string, reader common.IReader) error {
+	return writeFile(ctx, packPath(filename), filename, reader)
+}
+
+func (h *LocalHandler) WriteIndex(ctx context.Context, filename string, reader common.IReader) error {
+	return writeFile(ctx, indexDir, filename, reader)
+}
+
+func (h *LocalHandler) WriteSnapshot(ctx context.Context, filename string, reader common.IReader) error {
+	return writeFile(ctx, snapshotDir, filename, reader)
+}
+
+func (h *LocalHandler) WriteLock(ctx context.Context, filename string, reader common.IReader) error {
+	return writeFile(ctx, lockDir, filename, reader)
+}
 
+// writeFile writes a file into a subdirectory of the store, creating the
+// directory if needed
+func writeFile(ctx context.Context, dir string, filename string, reader common.IReader) error {
 	loc := getCtxLocation(ctx, LocationCtxKey("location"))
 	if loc == nil {
 		return ErrNoStoreLocation
 	}
 
-	err := warden.EnsureDir(path.Join(loc.(string), "keys"))
+	err := warden.EnsureDir(path.Join(loc.(string), dir))
 	if err != nil {
-		return fmt.Errorf("unable to create key dir: %+v", err)
+		return fmt.Errorf("unable to create %s dir: %+v", dir, err)
 	}
 
-	keyfileLoc := path.Join(loc.(string), "keys", filename)
-	warden.Log.Debug().Msgf("writing %s", keyfileLoc)
-	err = writeBytes(keyfileLoc, bReader.Reader, bReader.Len)
+	fileLoc := path.Join(loc.(string), dir, filename)
+	warden.Log.Debug().Msgf("writing %s", fileLoc)
+	err = writeBytes(fileLoc, reader)
 	if err != nil {
 		return err
 	}
@@ -68,45 +79,67 @@ func (h *LocalHandler) WriteKey(ctx context.Context, filename string, reader com
 	return nil
 }
 
-func (h *LocalHandler) WritePack(data []byte) error { return nil }
+// packPath shards packs into subdirectories by the first byte of their id
+func packPath(filename string) string {
+	if len(filename) < 2 {
+		return packDir
+	}
+	return path.Join(packDir, filename[:2])
+}
 
-func writeBytes(file string, reader io.Reader, readerLen int64) (err error) {
-	_, err = os.Stat(file)
+// writeBytes atomically writes a new read-only file. Data is streamed to a
+// temporary file in the same directory and renamed into place once synced,
+// so an interrupted or unverified write never leaves a partial file behind.
+func writeBytes(file string, reader common.IReader) (err error) {
+	if _, err = os.Stat(file); !os.IsNotExist(err) {
+		err = fmt.Errorf("file conflict: %s", file)
+		return
+	}
 
-	var f *os.File
-	var wroteBytes int64
+	f, err := os.CreateTemp(path.Dir(file), "."+path.Base(file)+".tmp-*")
+	if err != nil {
+		err = fmt.Errorf("unable to create file: %+v", err)
+		return
+	}
+	tmp := f.Name()
 
-	if os.IsNotExist(err) {
-		f, err = os.Create(file)
+	defer func() {
 		if err != nil {
-			err = fmt.Errorf("unable to create file: %+v", err)
-			return
+			f.Close()
+			os.Remove(tmp)
 		}
-		defer f.Close()
+	}()
 
-		wroteBytes, err = io.Copy(f, reader)
-		if err != nil {
-			return
-		}
+	src, err := common.Verified(reader)
+	if err != nil {
+		return
+	}
 
-		if wroteBytes != readerLen {
-			err = fmt.Errorf("expected to write %d bytes, wrote %d", readerLen, wroteBytes)
-			return
-		}
+	_, err = io.Copy(f, src)
+	if err != nil {
+		return
+	}
 
-		err = f.Close()
-		if err != nil {
-			return
-		}
+	err = src.Check()
+	if err != nil {
+		return
+	}
 
-		err = makeReadonly(file)
-		if err != nil {
-			return
-		}
-	} else {
-		err = fmt.Errorf("file conflict: %s", file)
+	err = f.Sync()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	if err != nil {
+		return
+	}
+
+	err = makeReadonly(tmp)
+	if err != nil {
 		return
 	}
 
+	err = os.Rename(tmp, file)
 	return
 }
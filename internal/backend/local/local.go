@@ -8,11 +8,11 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	pkgerr "github.com/pkg/errors"
 
 	"github.com/julianstephens/warden/internal/backend/common"
-	"github.com/julianstephens/warden/internal/storage"
 	"github.com/julianstephens/warden/internal/warden"
 )
 
@@ -25,6 +25,13 @@ type LocationCtxKey string
 
 const (
 	name = "LocalStorage"
+
+	configFile  = "config.json"
+	keyDir      = "keys"
+	packDir     = "packs"
+	indexDir    = "index"
+	snapshotDir = "snapshots"
+	lockDir     = "locks"
 )
 
 var (
@@ -98,38 +105,150 @@ func (l *Local) Save(ctx context.Context, event common.Event, reader common.IRea
 		if event.Name == nil {
 			return fmt.Errorf("no name provided for pack file")
 		}
-		// TODO: handle pack save
+		return l.WardenBackend.Handler.WritePack(ctx, *event.Name, reader)
+	case common.Index:
+		warden.Log.Debug().Msg("localstorage backend handling index save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for index file")
+		}
+		return l.WardenBackend.Handler.WriteIndex(ctx, *event.Name, reader)
+	case common.Snapshot:
+		warden.Log.Debug().Msg("localstorage backend handling snapshot save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for snapshot file")
+		}
+		return l.WardenBackend.Handler.WriteSnapshot(ctx, *event.Name, reader)
+	case common.Lock:
+		warden.Log.Debug().Msg("localstorage backend handling lock save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for lock file")
+		}
+		return l.WardenBackend.Handler.WriteLock(ctx, *event.Name, reader)
 	default:
 		return fmt.Errorf("got invalid event type: %s", event.Type.String())
 	}
+}
+
+func (l *Local) Load(ctx context.Context, event common.Event, offset int64, length int64) ([]byte, error) {
+	filePath, err := l.filePath(event)
+	if err != nil {
+		return nil, err
+	}
+
+	warden.Log.Debug().Msgf("reading %s", filePath)
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return common.ReadRange(f, offset, length)
+}
+
+func (l *Local) Stat(ctx context.Context, event common.Event) (common.FileInfo, error) {
+	filePath, err := l.filePath(event)
+	if err != nil {
+		return common.FileInfo{}, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return common.FileInfo{}, err
+	}
+
+	return common.FileInfo{Name: info.Name(), Size: info.Size()}, nil
+}
+
+func (l *Local) Exists(ctx context.Context, event common.Event) (bool, error) {
+	_, err := l.Stat(ctx, event)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
 
+	return err == nil, err
+}
+
+// Close is a no-op, local stores hold no connections
+func (l *Local) Close() error {
 	return nil
 }
 
-func (l *Local) ListSnapshots(ctx context.Context) ([]storage.Snapshot, error) {
-	var snaps []storage.Snapshot
+func (l *Local) Remove(ctx context.Context, event common.Event) error {
+	filePath, err := l.filePath(event)
+	if err != nil {
+		return err
+	}
 
-	snapDir := path.Join(l.location, "snapshots")
-	if _, err := os.Stat(snapDir); os.IsNotExist(err) {
-		return snaps, nil
+	warden.Log.Debug().Msgf("removing %s", filePath)
+	return os.Remove(filePath)
+}
+
+func (l *Local) List(ctx context.Context, t common.FileType) ([]string, error) {
+	var dir string
+	switch t {
+	case common.Key:
+		dir = keyDir
+	case common.Pack:
+		dir = packDir
+	case common.Index:
+		dir = indexDir
+	case common.Snapshot:
+		dir = snapshotDir
+	case common.Lock:
+		dir = lockDir
+	default:
+		return nil, fmt.Errorf("cannot list files of type: %s", t.String())
 	}
 
-	err := filepath.WalkDir(snapDir, func(path string, d fs.DirEntry, dirErr error) error {
-		if d.IsDir() {
-			return nil
-		}
+	var names []string
 
-		s, err := warden.LoadJSON[storage.Snapshot](path)
+	root := path.Join(l.location, dir)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return names, nil
+	}
+
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		snaps = append(snaps, s)
+		// skip shard directories and in-progress writes
+		if d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		names = append(names, strings.TrimSuffix(d.Name(), ".json"))
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	return snaps, nil
+	return names, nil
+}
+
+// filePath resolves the on-disk location of a file in the store
+func (l *Local) filePath(event common.Event) (string, error) {
+	if event.Type == common.Config {
+		return path.Join(l.location, configFile), nil
+	}
+
+	if event.Name == nil {
+		return "", fmt.Errorf("no name provided for %s file", event.Type.String())
+	}
+
+	switch event.Type {
+	case common.Key:
+		return path.Join(l.location, keyDir, fmt.Sprintf("%s.json", *event.Name)), nil
+	case common.Pack:
+		return path.Join(l.location, packPath(*event.Name), *event.Name), nil
+	case common.Index:
+		return path.Join(l.location, indexDir, *event.Name), nil
+	case common.Snapshot:
+		return path.Join(l.location, snapshotDir, *event.Name), nil
+	case common.Lock:
+		return path.Join(l.location, lockDir, *event.Name), nil
+	default:
+		return "", fmt.Errorf("got invalid event type: %s", event.Type.String())
+	}
 }
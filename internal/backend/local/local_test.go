@@ -0,0 +1,27 @@
+package local_test
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/julianstephens/warden/internal/backend/backendtest"
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/backend/local"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+func TestLocal(t *testing.T) {
+	warden.SetLog(warden.NewLog(os.Stderr, zerolog.ErrorLevel, time.RFC1123))
+
+	be, err := local.NewLocalStorage(common.LocalStorageParams{Location: path.Join(t.TempDir(), "store")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.Close()
+
+	backendtest.Run(t, be)
+}
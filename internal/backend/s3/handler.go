@@ -0,0 +1,35 @@
+package s3
+
+import (
+	"context"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+)
+
+type S3Handler struct {
+	backend *S3
+}
+
+func (h *S3Handler) WriteConfig(ctx context.Context, reader common.IReader) error {
+	return h.backend.putObject(ctx, h.backend.key(configFile), reader)
+}
+
+func (h *S3Handler) WriteKey(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.putObject(ctx, h.backend.key(keyDir, filename), reader)
+}
+
+func (h *S3Handler) WritePack(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.putObject(ctx, h.backend.key(packPath(filename), filename), reader)
+}
+
+func (h *S3Handler) WriteIndex(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.putObject(ctx, h.backend.key(indexDir, filename), reader)
+}
+
+func (h *S3Handler) WriteSnapshot(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.putObject(ctx, h.backend.key(snapshotDir, filename), reader)
+}
+
+func (h *S3Handler) WriteLock(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.putObject(ctx, h.backend.key(lockDir, filename), reader)
+}
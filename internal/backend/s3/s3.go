@@ -0,0 +1,371 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type S3 struct {
+	common.WardenBackend
+	client *minio.Client
+	params common.S3StorageParams
+}
+
+const (
+	name = "S3"
+
+	configFile  = "config.json"
+	keyDir      = "keys"
+	packDir     = "packs"
+	indexDir    = "index"
+	snapshotDir = "snapshots"
+	lockDir     = "locks"
+)
+
+var (
+	ErrNoBucket     = errors.New("no bucket provided")
+	ErrNoEndpoint   = errors.New("no endpoint provided")
+	ErrNoSuchBucket = errors.New("bucket does not exist")
+)
+
+// NewS3Storage connects to the bucket described by params. The bucket is
+// created if it does not exist and params.Create is set.
+func NewS3Storage(ctx context.Context, params common.S3StorageParams) (*S3, error) {
+	if params.Endpoint == "" {
+		return nil, ErrNoEndpoint
+	}
+	if params.Bucket == "" {
+		return nil, ErrNoBucket
+	}
+
+	lookup := minio.BucketLookupAuto
+	if params.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(params.Endpoint, &minio.Options{
+		Creds:        newCredentials(params),
+		Secure:       !params.Insecure,
+		Region:       params.Region,
+		BucketLookup: lookup,
+		Transport:    params.Transport,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create s3 client: %+v", err)
+	}
+
+	s := &S3{client: client, params: params}
+	s.WardenBackend = common.WardenBackend{Self: common.S3, Name: name, Handler: &S3Handler{backend: s}}
+
+	if params.Create {
+		err = s.scaffold(ctx)
+	} else {
+		err = s.checkBucket(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// newCredentials uses the keys from params when set, falling back to the
+// AWS and MinIO environment variables, the AWS credentials file and IAM
+func newCredentials(params common.S3StorageParams) *credentials.Credentials {
+	if params.AccessKey != "" || params.SecretKey != "" {
+		return credentials.NewStaticV4(params.AccessKey, params.SecretKey, params.SessionToken)
+	}
+
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+	})
+}
+
+// checkBucket fails if the bucket does not exist
+func (s *S3) checkBucket(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.params.Bucket)
+	if err != nil {
+		return fmt.Errorf("unable to check bucket %s: %+v", s.params.Bucket, err)
+	}
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrNoSuchBucket, s.params.Bucket)
+	}
+
+	return nil
+}
+
+func (s *S3) scaffold(ctx context.Context) error {
+	exists, err := s.client.BucketExists(ctx, s.params.Bucket)
+	if err != nil {
+		return fmt.Errorf("unable to check bucket %s: %+v", s.params.Bucket, err)
+	}
+	if exists {
+		return nil
+	}
+
+	warden.Log.Debug().Msgf("creating bucket %s", s.params.Bucket)
+	err = s.client.MakeBucket(ctx, s.params.Bucket, minio.MakeBucketOptions{Region: s.params.Region})
+	if err != nil {
+		return fmt.Errorf("unable to create bucket %s: %+v", s.params.Bucket, err)
+	}
+
+	return nil
+}
+
+func (s *S3) Save(ctx context.Context, event common.Event, reader common.IReader) error {
+	switch event.Type {
+	case common.Key:
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for key file")
+		}
+		warden.Log.Debug().Msg("s3 backend handling key save event...")
+		return s.WardenBackend.Handler.WriteKey(ctx, fmt.Sprintf("%s.json", *event.Name), reader)
+	case common.Config:
+		warden.Log.Debug().Msg("s3 backend handling config save event...")
+		return s.WardenBackend.Handler.WriteConfig(ctx, reader)
+	case common.Pack:
+		warden.Log.Debug().Msg("s3 backend handling pack save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for pack file")
+		}
+		return s.WardenBackend.Handler.WritePack(ctx, *event.Name, reader)
+	case common.Index:
+		warden.Log.Debug().Msg("s3 backend handling index save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for index file")
+		}
+		return s.WardenBackend.Handler.WriteIndex(ctx, *event.Name, reader)
+	case common.Snapshot:
+		warden.Log.Debug().Msg("s3 backend handling snapshot save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for snapshot file")
+		}
+		return s.WardenBackend.Handler.WriteSnapshot(ctx, *event.Name, reader)
+	case common.Lock:
+		warden.Log.Debug().Msg("s3 backend handling lock save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for lock file")
+		}
+		return s.WardenBackend.Handler.WriteLock(ctx, *event.Name, reader)
+	default:
+		return fmt.Errorf("got invalid event type: %s", event.Type.String())
+	}
+}
+
+// Load reads an object or a range of it
+func (s *S3) Load(ctx context.Context, event common.Event, offset int64, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 {
+		return nil, fmt.Errorf("invalid range: offset %d, length %d", offset, length)
+	}
+
+	key, err := s.objectKey(event)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := minio.GetObjectOptions{}
+	switch {
+	case length > 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	warden.Log.Debug().Msgf("reading s3://%s/%s", s.params.Bucket, key)
+	obj, err := s.client.GetObject(ctx, s.params.Bucket, key, opts)
+	if err != nil {
+		return nil, s.wrapError(key, err)
+	}
+	defer obj.Close()
+
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return nil, s.wrapError(key, err)
+	}
+
+	if length > 0 && int64(len(data)) != length {
+		return nil, fmt.Errorf("unable to read %d bytes at offset %d: %w", length, offset, io.ErrUnexpectedEOF)
+	}
+
+	return data, nil
+}
+
+func (s *S3) Stat(ctx context.Context, event common.Event) (common.FileInfo, error) {
+	key, err := s.objectKey(event)
+	if err != nil {
+		return common.FileInfo{}, err
+	}
+
+	info, err := s.client.StatObject(ctx, s.params.Bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return common.FileInfo{}, s.wrapError(key, err)
+	}
+
+	return common.FileInfo{Name: path.Base(info.Key), Size: info.Size}, nil
+}
+
+func (s *S3) Exists(ctx context.Context, event common.Event) (bool, error) {
+	_, err := s.Stat(ctx, event)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Close is a no-op, requests do not share a session
+func (s *S3) Close() error {
+	return nil
+}
+
+func (s *S3) Remove(ctx context.Context, event common.Event) error {
+	key, err := s.objectKey(event)
+	if err != nil {
+		return err
+	}
+
+	warden.Log.Debug().Msgf("removing s3://%s/%s", s.params.Bucket, key)
+	return s.client.RemoveObject(ctx, s.params.Bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) List(ctx context.Context, t common.FileType) ([]string, error) {
+	var dir string
+	switch t {
+	case common.Key:
+		dir = keyDir
+	case common.Pack:
+		dir = packDir
+	case common.Index:
+		dir = indexDir
+	case common.Snapshot:
+		dir = snapshotDir
+	case common.Lock:
+		dir = lockDir
+	default:
+		return nil, fmt.Errorf("cannot list files of type: %s", t.String())
+	}
+
+	var names []string
+	opts := minio.ListObjectsOptions{Prefix: s.key(dir) + "/", Recursive: true}
+	for obj := range s.client.ListObjects(ctx, s.params.Bucket, opts) {
+		if obj.Err != nil {
+			return nil, fmt.Errorf("unable to list %s: %+v", dir, obj.Err)
+		}
+
+		// packs are sharded like the local backend, only the base name is
+		// the file name
+		names = append(names, strings.TrimSuffix(path.Base(obj.Key), ".json"))
+	}
+
+	return names, nil
+}
+
+// putObject streams an object unless it already exists. Objects larger than
+// the configured part size are sent as a multipart upload, buffering one part
+// at a time.
+func (s *S3) putObject(ctx context.Context, key string, reader common.IReader) error {
+	_, err := s.client.StatObject(ctx, s.params.Bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return fmt.Errorf("file conflict: %s", key)
+	}
+	if err = s.wrapError(key, err); !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	src, err := common.Verified(reader)
+	if err != nil {
+		return err
+	}
+
+	size := reader.Length()
+	warden.Log.Debug().Msgf("writing s3://%s/%s", s.params.Bucket, key)
+	info, err := s.client.PutObject(ctx, s.params.Bucket, key, src, size, minio.PutObjectOptions{
+		ContentType:  "application/octet-stream",
+		StorageClass: s.params.StorageClass,
+		PartSize:     s.params.PartSize,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upload %s: %+v", key, err)
+	}
+
+	err = src.Check()
+	if err == nil && info.Size != size {
+		err = fmt.Errorf("expected to write %d bytes, wrote %d", size, info.Size)
+	}
+	if err != nil {
+		// the upload completed with content that failed verification
+		if rmErr := s.client.RemoveObject(ctx, s.params.Bucket, key, minio.RemoveObjectOptions{}); rmErr != nil {
+			warden.Log.Error().Msgf("unable to remove unverified object %s: %+v", key, rmErr)
+		}
+		return fmt.Errorf("unable to upload %s: %+v", key, err)
+	}
+	warden.Log.Debug().Msg("write successful.")
+
+	return nil
+}
+
+// objectKey resolves the key of a file in the bucket. The layout matches the
+// local backend.
+func (s *S3) objectKey(event common.Event) (string, error) {
+	if event.Type == common.Config {
+		return s.key(configFile), nil
+	}
+
+	if event.Name == nil {
+		return "", fmt.Errorf("no name provided for %s file", event.Type.String())
+	}
+
+	switch event.Type {
+	case common.Key:
+		return s.key(keyDir, fmt.Sprintf("%s.json", *event.Name)), nil
+	case common.Pack:
+		return s.key(packPath(*event.Name), *event.Name), nil
+	case common.Index:
+		return s.key(indexDir, *event.Name), nil
+	case common.Snapshot:
+		return s.key(snapshotDir, *event.Name), nil
+	case common.Lock:
+		return s.key(lockDir, *event.Name), nil
+	default:
+		return "", fmt.Errorf("got invalid event type: %s", event.Type.String())
+	}
+}
+
+// key joins elem under the store prefix
+func (s *S3) key(elem ...string) string {
+	return path.Join(append([]string{s.params.Prefix}, elem...)...)
+}
+
+// packPath shards packs into prefixes by the first byte of their id
+func packPath(filename string) string {
+	if len(filename) < 2 {
+		return packDir
+	}
+	return path.Join(packDir, filename[:2])
+}
+
+func (s *S3) wrapError(key string, err error) error {
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%s: %w", key, fs.ErrNotExist)
+	}
+
+	return fmt.Errorf("s3://%s/%s: %+v", s.params.Bucket, key, err)
+}
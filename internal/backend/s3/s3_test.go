@@ -0,0 +1,104 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/rs/zerolog"
+
+	"github.com/julianstephens/warden/internal/backend/backendtest"
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/backend/s3"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+func newFakeS3(t *testing.T, prefix string) *s3.S3 {
+	params := fakeS3Params(t, prefix)
+	params.Create = true
+
+	be, err := s3.NewS3Storage(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return be
+}
+
+func fakeS3Params(t *testing.T, prefix string) common.S3StorageParams {
+	warden.SetLog(warden.NewLog(os.Stderr, zerolog.ErrorLevel, time.RFC1123))
+	server := httptest.NewTLSServer(gofakes3.New(s3mem.New()).Server())
+	t.Cleanup(server.Close)
+
+	return common.S3StorageParams{
+		Endpoint:  strings.TrimPrefix(server.URL, "https://"),
+		Bucket:    "warden",
+		Prefix:    prefix,
+		Region:    "us-east-1",
+		AccessKey: "access",
+		SecretKey: "secretsecret",
+		PathStyle: true,
+		PartSize:  5 * 1024 * 1024,
+		Transport: server.Client().Transport,
+	}
+}
+
+func TestS3(t *testing.T) {
+	backendtest.Run(t, newFakeS3(t, "stores/test"))
+}
+
+func TestS3MissingBucket(t *testing.T) {
+	ctx := context.Background()
+	params := fakeS3Params(t, "")
+
+	_, err := s3.NewS3Storage(ctx, params)
+	if !errors.Is(err, s3.ErrNoSuchBucket) {
+		t.Fatalf("expected error %+v, got %+v", s3.ErrNoSuchBucket, err)
+	}
+
+	params.Create = true
+	_, err = s3.NewS3Storage(ctx, params)
+	if err != nil {
+		t.Fatalf("expected bucket to be created, got err: %+v", err)
+	}
+
+	params.Create = false
+	_, err = s3.NewS3Storage(ctx, params)
+	if err != nil {
+		t.Fatalf("expected existing bucket to open, got err: %+v", err)
+	}
+}
+
+func TestS3Multipart(t *testing.T) {
+	ctx := context.Background()
+	be := newFakeS3(t, "")
+
+	data := make([]byte, 12*1024*1024)
+	rand.Read(data)
+
+	event := common.Event{Type: common.Pack, Name: ptr("0123456789")}
+	err := be.Save(ctx, event, common.NewByteReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := be.Load(ctx, event, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("expected %d byte pack to round trip, got %d bytes", len(data), len(got))
+	}
+}
+
+func ptr(s string) *string {
+	return &s
+}
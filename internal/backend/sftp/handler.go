@@ -0,0 +1,35 @@
+package sftp
+
+import (
+	"context"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+)
+
+type SFTPHandler struct {
+	backend *SFTP
+}
+
+func (h *SFTPHandler) WriteConfig(ctx context.Context, reader common.IReader) error {
+	return h.backend.writeFile(".", configFile, reader)
+}
+
+func (h *SFTPHandler) WriteKey(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.writeFile(keyDir, filename, reader)
+}
+
+func (h *SFTPHandler) WritePack(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.writeFile(packPath(filename), filename, reader)
+}
+
+func (h *SFTPHandler) WriteIndex(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.writeFile(indexDir, filename, reader)
+}
+
+func (h *SFTPHandler) WriteSnapshot(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.writeFile(snapshotDir, filename, reader)
+}
+
+func (h *SFTPHandler) WriteLock(ctx context.Context, filename string, reader common.IReader) error {
+	return h.backend.writeFile(lockDir, filename, reader)
+}
@@ -0,0 +1,430 @@
+package sftp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type SFTP struct {
+	common.WardenBackend
+	conn     *ssh.Client
+	client   *pkgsftp.Client
+	location string
+	// agentConn is the ssh agent connection used to authenticate, if any
+	agentConn net.Conn
+}
+
+const (
+	name        = "SFTP"
+	defaultPort = 22
+
+	configFile  = "config.json"
+	keyDir      = "keys"
+	packDir     = "packs"
+	indexDir    = "index"
+	snapshotDir = "snapshots"
+	lockDir     = "locks"
+)
+
+var (
+	ErrNoHost     = errors.New("no host provided")
+	ErrNoAuth     = errors.New("no identity file or ssh agent available")
+	ErrNoLocation = errors.New("no store path provided")
+)
+
+// NewSFTPStorage connects to the server described by params and creates the
+// store directory if it does not exist
+func NewSFTPStorage(ctx context.Context, params common.SFTPStorageParams) (*SFTP, error) {
+	if params.Host == "" {
+		return nil, ErrNoHost
+	}
+	if params.Path == "" {
+		return nil, ErrNoLocation
+	}
+
+	config, agentConn, err := clientConfig(params)
+	if err != nil {
+		return nil, err
+	}
+	closeAgent := func() {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+	}
+
+	port := params.Port
+	if port == 0 {
+		port = defaultPort
+	}
+	addr := net.JoinHostPort(params.Host, strconv.Itoa(port))
+
+	warden.Log.Debug().Msgf("connecting to %s...", addr)
+	var d net.Dialer
+	netConn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		closeAgent()
+		return nil, fmt.Errorf("unable to connect to %s: %+v", addr, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(netConn, addr, config)
+	if err != nil {
+		netConn.Close()
+		closeAgent()
+		return nil, fmt.Errorf("unable to start ssh session with %s: %+v", addr, err)
+	}
+	conn := ssh.NewClient(sshConn, chans, reqs)
+
+	client, err := pkgsftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		closeAgent()
+		return nil, fmt.Errorf("unable to start sftp session with %s: %+v", addr, err)
+	}
+
+	s := &SFTP{
+		WardenBackend: common.WardenBackend{Self: common.SFTP, Name: name},
+		conn:          conn,
+		client:        client,
+		location:      params.Path,
+		agentConn:     agentConn,
+	}
+	s.Handler = &SFTPHandler{backend: s}
+
+	err = client.MkdirAll(s.location)
+	if err != nil {
+		s.Close()
+		return nil, fmt.Errorf("unable to create store dir %s: %+v", s.location, err)
+	}
+
+	return s, nil
+}
+
+// clientConfig authenticates with the identity file and the ssh agent, and
+// verifies the server against known_hosts. The returned agent connection, if
+// any, must stay open for the lifetime of the ssh session.
+func clientConfig(params common.SFTPStorageParams) (config *ssh.ClientConfig, agentConn net.Conn, err error) {
+	var auth []ssh.AuthMethod
+
+	if params.IdentityFile != "" {
+		pem, err := os.ReadFile(params.IdentityFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read identity file: %+v", err)
+		}
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse identity file: %+v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		agentConn, err = net.Dial("unix", sock)
+		if err != nil {
+			warden.Log.Debug().Msgf("unable to connect to ssh agent: %+v", err)
+			agentConn = nil
+		} else {
+			auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(agentConn).Signers))
+		}
+	}
+
+	if len(auth) == 0 {
+		return nil, nil, ErrNoAuth
+	}
+
+	hostKeys, err := hostKeyCallback(params.KnownHostsFile)
+	if err != nil {
+		if agentConn != nil {
+			agentConn.Close()
+		}
+		return nil, nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            params.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	}, agentConn, nil
+}
+
+// hostKeyCallback verifies servers against knownHostsFile, or the user's
+// known_hosts if it is empty
+func hostKeyCallback(knownHostsFile string) (ssh.HostKeyCallback, error) {
+	if knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("unable to find known_hosts: %+v", err)
+		}
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read known hosts: %+v", err)
+	}
+
+	return hostKeys, nil
+}
+
+// Close ends the sftp and ssh sessions and the ssh agent connection
+func (s *SFTP) Close() error {
+	err := s.client.Close()
+	if connErr := s.conn.Close(); err == nil {
+		err = connErr
+	}
+	if s.agentConn != nil {
+		if agentErr := s.agentConn.Close(); err == nil {
+			err = agentErr
+		}
+	}
+
+	return err
+}
+
+func (s *SFTP) Save(ctx context.Context, event common.Event, reader common.IReader) error {
+	switch event.Type {
+	case common.Key:
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for key file")
+		}
+		warden.Log.Debug().Msg("sftp backend handling key save event...")
+		return s.WardenBackend.Handler.WriteKey(ctx, fmt.Sprintf("%s.json", *event.Name), reader)
+	case common.Config:
+		warden.Log.Debug().Msg("sftp backend handling config save event...")
+		return s.WardenBackend.Handler.WriteConfig(ctx, reader)
+	case common.Pack:
+		warden.Log.Debug().Msg("sftp backend handling pack save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for pack file")
+		}
+		return s.WardenBackend.Handler.WritePack(ctx, *event.Name, reader)
+	case common.Index:
+		warden.Log.Debug().Msg("sftp backend handling index save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for index file")
+		}
+		return s.WardenBackend.Handler.WriteIndex(ctx, *event.Name, reader)
+	case common.Snapshot:
+		warden.Log.Debug().Msg("sftp backend handling snapshot save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for snapshot file")
+		}
+		return s.WardenBackend.Handler.WriteSnapshot(ctx, *event.Name, reader)
+	case common.Lock:
+		warden.Log.Debug().Msg("sftp backend handling lock save event...")
+		if event.Name == nil {
+			return fmt.Errorf("no name provided for lock file")
+		}
+		return s.WardenBackend.Handler.WriteLock(ctx, *event.Name, reader)
+	default:
+		return fmt.Errorf("got invalid event type: %s", event.Type.String())
+	}
+}
+
+func (s *SFTP) Load(ctx context.Context, event common.Event, offset int64, length int64) ([]byte, error) {
+	filePath, err := s.filePath(event)
+	if err != nil {
+		return nil, err
+	}
+
+	warden.Log.Debug().Msgf("reading sftp:%s", filePath)
+	f, err := s.client.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return common.ReadRange(f, offset, length)
+}
+
+func (s *SFTP) Stat(ctx context.Context, event common.Event) (common.FileInfo, error) {
+	filePath, err := s.filePath(event)
+	if err != nil {
+		return common.FileInfo{}, err
+	}
+
+	info, err := s.client.Stat(filePath)
+	if err != nil {
+		return common.FileInfo{}, err
+	}
+
+	return common.FileInfo{Name: info.Name(), Size: info.Size()}, nil
+}
+
+func (s *SFTP) Exists(ctx context.Context, event common.Event) (bool, error) {
+	_, err := s.Stat(ctx, event)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (s *SFTP) Remove(ctx context.Context, event common.Event) error {
+	filePath, err := s.filePath(event)
+	if err != nil {
+		return err
+	}
+
+	warden.Log.Debug().Msgf("removing sftp:%s", filePath)
+	return s.client.Remove(filePath)
+}
+
+func (s *SFTP) List(ctx context.Context, t common.FileType) ([]string, error) {
+	var dir string
+	switch t {
+	case common.Key:
+		dir = keyDir
+	case common.Pack:
+		dir = packDir
+	case common.Index:
+		dir = indexDir
+	case common.Snapshot:
+		dir = snapshotDir
+	case common.Lock:
+		dir = lockDir
+	default:
+		return nil, fmt.Errorf("cannot list files of type: %s", t.String())
+	}
+
+	var names []string
+
+	walker := s.client.Walk(path.Join(s.location, dir))
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) && walker.Path() == path.Join(s.location, dir) {
+				return names, nil
+			}
+			return nil, err
+		}
+
+		// skip shard directories and in-progress writes
+		info := walker.Stat()
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+
+		names = append(names, strings.TrimSuffix(info.Name(), ".json"))
+	}
+
+	return names, nil
+}
+
+// writeFile atomically writes a new read-only file. Data is streamed to a
+// temporary file in the same directory and renamed into place once complete,
+// so an interrupted or unverified upload never leaves a partial file behind.
+func (s *SFTP) writeFile(dir string, filename string, reader common.IReader) (err error) {
+	dir = path.Join(s.location, dir)
+	err = s.client.MkdirAll(dir)
+	if err != nil {
+		return fmt.Errorf("unable to create %s dir: %+v", dir, err)
+	}
+
+	file := path.Join(dir, filename)
+	_, err = s.client.Stat(file)
+	if err == nil {
+		return fmt.Errorf("file conflict: %s", file)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to stat %s: %+v", file, err)
+	}
+
+	tmp := path.Join(dir, fmt.Sprintf(".%s.tmp-%s", filename, warden.NewID().String()[:8]))
+	f, err := s.client.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
+	if err != nil {
+		return fmt.Errorf("unable to create file: %+v", err)
+	}
+
+	defer func() {
+		if err != nil {
+			f.Close()
+			s.client.Remove(tmp)
+		}
+	}()
+
+	warden.Log.Debug().Msgf("writing sftp:%s", file)
+	src, err := common.Verified(reader)
+	if err != nil {
+		return
+	}
+
+	_, err = f.ReadFrom(src)
+	if err != nil {
+		return
+	}
+
+	err = src.Check()
+	if err != nil {
+		return
+	}
+
+	err = f.Close()
+	if err != nil {
+		return
+	}
+
+	err = s.client.Chmod(tmp, 0444)
+	if err != nil {
+		err = fmt.Errorf("unable to make file read-only: %+v", err)
+		return
+	}
+
+	// plain sftp renames refuse to replace an existing file
+	err = s.client.Rename(tmp, file)
+	if err != nil {
+		return
+	}
+	warden.Log.Debug().Msg("write successful.")
+
+	return
+}
+
+// filePath resolves the location of a file on the server
+func (s *SFTP) filePath(event common.Event) (string, error) {
+	if event.Type == common.Config {
+		return path.Join(s.location, configFile), nil
+	}
+
+	if event.Name == nil {
+		return "", fmt.Errorf("no name provided for %s file", event.Type.String())
+	}
+
+	switch event.Type {
+	case common.Key:
+		return path.Join(s.location, keyDir, fmt.Sprintf("%s.json", *event.Name)), nil
+	case common.Pack:
+		return path.Join(s.location, packPath(*event.Name), *event.Name), nil
+	case common.Index:
+		return path.Join(s.location, indexDir, *event.Name), nil
+	case common.Snapshot:
+		return path.Join(s.location, snapshotDir, *event.Name), nil
+	case common.Lock:
+		return path.Join(s.location, lockDir, *event.Name), nil
+	default:
+		return "", fmt.Errorf("got invalid event type: %s", event.Type.String())
+	}
+}
+
+// packPath shards packs into subdirectories by the first byte of their id
+func packPath(filename string) string {
+	if len(filename) < 2 {
+		return packDir
+	}
+	return path.Join(packDir, filename[:2])
+}
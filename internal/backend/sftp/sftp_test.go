@@ -0,0 +1,244 @@
+package sftp_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	pkgsftp "github.com/pkg/sftp"
+	"github.com/rs/zerolog"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/julianstephens/warden/internal/backend/backendtest"
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/backend/sftp"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type testServer struct {
+	host     string
+	port     int
+	hostKey  ssh.PublicKey
+	clientPK ed25519.PrivateKey
+}
+
+// startServer runs an in-process ssh server with an sftp subsystem that only
+// accepts the returned client key
+func startServer(t *testing.T) *testServer {
+	warden.SetLog(warden.NewLog(os.Stderr, zerolog.ErrorLevel, time.RFC1123))
+
+	_, hostPK, _ := ed25519.GenerateKey(rand.Reader)
+	hostSigner, err := ssh.NewSignerFromKey(hostPK)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientPub, clientPK, _ := ed25519.GenerateKey(rand.Reader)
+	authorized, err := ssh.NewPublicKey(clientPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return &testServer{host: addr.IP.String(), port: addr.Port, hostKey: hostSigner.PublicKey(), clientPK: clientPK}
+}
+
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range chReqs {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				server, err := pkgsftp.NewServer(ch)
+				if err == nil {
+					server.Serve()
+				}
+				ch.Close()
+			}
+		}()
+	}
+}
+
+// knownHosts writes a known_hosts file trusting key for the server
+func (s *testServer) knownHosts(t *testing.T, key ssh.PublicKey) string {
+	file := path.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(s.host, strconv.Itoa(s.port)))}, key)
+	if err := os.WriteFile(file, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func (s *testServer) identityFile(t *testing.T) string {
+	block, err := ssh.MarshalPrivateKey(s.clientPK, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := path.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func (s *testServer) params(t *testing.T, location string) common.SFTPStorageParams {
+	return common.SFTPStorageParams{
+		Host:           s.host,
+		Port:           s.port,
+		User:           "warden",
+		Path:           location,
+		KnownHostsFile: s.knownHosts(t, s.hostKey),
+	}
+}
+
+func TestSFTP(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	server := startServer(t)
+	location := path.Join(t.TempDir(), "store")
+
+	params := server.params(t, location)
+	params.IdentityFile = server.identityFile(t)
+
+	be, err := sftp.NewSFTPStorage(context.Background(), params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer be.Close()
+
+	backendtest.Run(t, be)
+
+	// the layout matches the local backend
+	for _, file := range []string{"config.json", "packs/ab/abcdef", "index/i1", "snapshots/s1"} {
+		if _, err := os.Stat(filepath.Join(location, file)); err != nil {
+			t.Fatalf("expected %s in store: %+v", file, err)
+		}
+	}
+}
+
+func TestSFTPAgent(t *testing.T) {
+	server := startServer(t)
+
+	keyring := agent.NewKeyring()
+	err := keyring.Add(agent.AddedKey{PrivateKey: server.clientPK})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sock := path.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// ServeAgent returns once the client closes its connection
+	served := make(chan struct{}, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				agent.ServeAgent(keyring, conn)
+				served <- struct{}{}
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	be, err := sftp.NewSFTPStorage(context.Background(), server.params(t, t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	be.Close()
+
+	select {
+	case <-served:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to close the ssh agent connection")
+	}
+}
+
+func TestSFTPHostKeyMismatch(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	server := startServer(t)
+
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, err := ssh.NewPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	params := server.params(t, t.TempDir())
+	params.IdentityFile = server.identityFile(t)
+	params.KnownHostsFile = server.knownHosts(t, otherKey)
+
+	_, err = sftp.NewSFTPStorage(context.Background(), params)
+	if err == nil {
+		t.Fatalf("expected connecting to a host with an unknown key to fail")
+	}
+}
@@ -1,16 +1,21 @@
 package chunker
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"math"
-	"math/rand"
 
 	"github.com/alecthomas/units"
 )
 
 const (
 	normalization = 2
-	normalSize    = 8 * units.KB
+
+	minChunkSize = 64
+	maxChunkSize = 64 * units.MiB
 )
 
 type Chunker struct {
@@ -26,7 +31,7 @@ type Chunker struct {
 	cursor  int
 	offset  int
 
-	seed uint64
+	gear *[256]uint64
 
 	closed bool
 }
@@ -38,30 +43,103 @@ type Chunk struct {
 	Fingerprint uint64
 }
 
+// Options configures chunk sizes and the secret that chunk boundaries are
+// derived from. Zero sizes fall back to DefaultOptions.
 type Options struct {
+	MinSize     int
 	AverageSize int
-	Seed        int
+	MaxSize     int
+	// Seed keys the gear table. Without it the public default table is
+	// used and chunk sizes can reveal which files are stored.
+	Seed []byte
+}
+
+var DefaultOptions = Options{
+	MinSize:     int(2 * units.KiB),
+	AverageSize: int(8 * units.KiB),
+	MaxSize:     int(64 * units.KiB),
 }
 
-func NewChunker(reader io.Reader) *Chunker {
-	bits := int(math.Round(math.Log2(float64(normalSize))))
+// Validate checks that chunk sizes are ordered and within bounds
+func (o Options) Validate() error {
+	o = o.withDefaults()
+
+	if o.MinSize < minChunkSize {
+		return fmt.Errorf("minimum chunk size must be at least %d bytes, got %d", minChunkSize, o.MinSize)
+	}
+
+	if o.MaxSize > int(maxChunkSize) {
+		return fmt.Errorf("maximum chunk size must be at most %d bytes, got %d", int(maxChunkSize), o.MaxSize)
+	}
+
+	if o.MinSize >= o.AverageSize || o.AverageSize >= o.MaxSize {
+		return fmt.Errorf("chunk sizes must satisfy min < avg < max, got %d, %d, %d", o.MinSize, o.AverageSize, o.MaxSize)
+	}
+
+	return nil
+}
+
+func (o Options) withDefaults() Options {
+	if o.MinSize == 0 {
+		o.MinSize = DefaultOptions.MinSize
+	}
+	if o.AverageSize == 0 {
+		o.AverageSize = DefaultOptions.AverageSize
+	}
+	if o.MaxSize == 0 {
+		o.MaxSize = DefaultOptions.MaxSize
+	}
+	return o
+}
+
+// NewChunker splits reader into content-defined chunks. Boundaries depend
+// only on the content and opts, so the same data always chunks the same way
+// within a store.
+func NewChunker(reader io.Reader, opts Options) *Chunker {
+	opts = opts.withDefaults()
+	bits := int(math.Round(math.Log2(float64(opts.AverageSize))))
 
 	c := &Chunker{
-		minSize: int(normalSize) / 4,
-		avgSize: int(normalSize),
-		maxSize: int(normalSize) * 8,
+		minSize: opts.MinSize,
+		avgSize: opts.AverageSize,
+		maxSize: opts.MaxSize,
 		maskS:   uint64(mask(bits + normalization)),
 		maskL:   uint64(mask(bits - normalization)),
-		cursor:  int(normalSize) * 8 * 2,
+		cursor:  opts.MaxSize * 2,
 		offset:  0,
 		data:    reader,
-		curData: make([]byte, normalSize*8*2),
-		seed:    rand.Uint64(),
+		curData: make([]byte, opts.MaxSize*2),
+		gear:    newGearTable(opts.Seed),
 	}
 
 	return c
 }
 
+// newGearTable derives a secret gear table from seed. Each entry is read
+// from HMAC-SHA256(seed, block) so the table cannot be recovered without
+// the seed.
+func newGearTable(seed []byte) *[256]uint64 {
+	if len(seed) == 0 {
+		return &gearTable
+	}
+
+	var table [256]uint64
+	mac := hmac.New(sha256.New, seed)
+	perBlock := sha256.Size / 8
+
+	for block := 0; block < len(table)/perBlock; block++ {
+		mac.Reset()
+		mac.Write([]byte{byte(block)})
+		sum := mac.Sum(nil)
+
+		for j := 0; j < perBlock; j++ {
+			table[block*perBlock+j] = binary.LittleEndian.Uint64(sum[j*8:])
+		}
+	}
+
+	return &table
+}
+
 func (c *Chunker) Next() (Chunk, error) {
 	if err := c.getCurData(); err != nil {
 		return Chunk{}, err
@@ -123,14 +201,14 @@ func (c *Chunker) getNextChunk() Chunk {
 	n := min(len(buf), c.maxSize)
 
 	for ; i < min(n, c.avgSize); i++ {
-		fp = (fp << 1) + gearTable[buf[i]]
+		fp = (fp << 1) + c.gear[buf[i]]
 		if (fp & c.maskS) == 0 {
 			return Chunk{Offset: c.offset, Length: i + 1, Data: c.curData[c.cursor : c.cursor+(i+1)], Fingerprint: fp}
 		}
 	}
 
 	for ; i < n; i++ {
-		fp = (fp << 1) + gearTable[buf[i]]
+		fp = (fp << 1) + c.gear[buf[i]]
 		if (fp & c.maskL) == 0 {
 			return Chunk{Offset: c.offset, Length: i + 1, Data: c.curData[c.cursor : c.cursor+(i+1)], Fingerprint: fp}
 		}
@@ -0,0 +1,88 @@
+package chunker_test
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/chunker"
+)
+
+func chunkLengths(t *testing.T, data []byte, opts chunker.Options) []int {
+	t.Helper()
+
+	var lengths []int
+	var joined []byte
+
+	c := chunker.NewChunker(bytes.NewReader(data), opts)
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lengths = append(lengths, chunk.Length)
+		joined = append(joined, chunk.Data...)
+	}
+
+	if !bytes.Equal(joined, data) {
+		t.Fatal("expected chunks to reassemble the original data")
+	}
+
+	return lengths
+}
+
+func TestChunker(t *testing.T) {
+	data := make([]byte, 1024*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	opts := chunker.Options{MinSize: 1024, AverageSize: 4096, MaxSize: 16384, Seed: []byte("store seed")}
+
+	lengths := chunkLengths(t, data, opts)
+	for i, l := range lengths[:len(lengths)-1] {
+		if l < opts.MinSize || l > opts.MaxSize {
+			t.Fatalf("chunk %d has length %d outside of [%d, %d]", i, l, opts.MinSize, opts.MaxSize)
+		}
+	}
+
+	if again := chunkLengths(t, data, opts); !slices.Equal(lengths, again) {
+		t.Fatal("expected the same seed to produce the same chunk boundaries")
+	}
+
+	other := opts
+	other.Seed = []byte("other seed")
+	if slices.Equal(lengths, chunkLengths(t, data, other)) {
+		t.Fatal("expected a different seed to produce different chunk boundaries")
+	}
+
+	if slices.Equal(lengths, chunkLengths(t, data, chunker.Options{MinSize: 1024, AverageSize: 4096, MaxSize: 16384})) {
+		t.Fatal("expected a seed to change chunk boundaries from the default table")
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		input chunker.Options
+		valid bool
+	}{
+		{name: "should accept defaults", input: chunker.Options{}, valid: true},
+		{name: "should accept ordered sizes", input: chunker.Options{MinSize: 512, AverageSize: 1024, MaxSize: 4096}, valid: true},
+		{name: "should reject min above avg", input: chunker.Options{MinSize: 8192, AverageSize: 4096, MaxSize: 16384}, valid: false},
+		{name: "should reject tiny chunks", input: chunker.Options{MinSize: 1, AverageSize: 4096, MaxSize: 16384}, valid: false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.input.Validate()
+			if c.valid != (err == nil) {
+				t.Fatalf("expected valid=%t, got err: %+v", c.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package compress
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+type Algorithm byte
+
+const (
+	// None stores data uncompressed
+	None Algorithm = iota
+	// S2 is the fast option, trading ratio for throughput
+	S2
+	// Zstd is the strong option, for stores where size matters most
+	Zstd
+)
+
+const (
+	DefaultAlgorithm = Zstd
+	DefaultLevel     = 3
+)
+
+var (
+	ErrInvalidAlgorithm = errors.New("invalid compression algorithm")
+	ErrInvalidLevel     = errors.New("invalid compression level")
+
+	// Algorithms lists the valid algorithm names
+	Algorithms = []string{None.String(), S2.String(), Zstd.String()}
+
+	decoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case None:
+		return "none"
+	case S2:
+		return "s2"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Algorithm(%d)", byte(a))
+	}
+}
+
+// ParseAlgorithm returns the algorithm with the given name
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for _, a := range []Algorithm{None, S2, Zstd} {
+		if strings.EqualFold(name, a.String()) {
+			return a, nil
+		}
+	}
+
+	return None, fmt.Errorf("%w: %q", ErrInvalidAlgorithm, name)
+}
+
+// LevelRange returns the levels an algorithm supports. ok is false for
+// algorithms without levels.
+func (a Algorithm) LevelRange() (min int, max int, ok bool) {
+	switch a {
+	case S2:
+		return 1, 9, true
+	case Zstd:
+		return 1, 22, true
+	default:
+		return 0, 0, false
+	}
+}
+
+// ValidateLevel reports whether an algorithm supports level. Algorithms
+// without levels ignore it.
+func ValidateLevel(algorithm Algorithm, level int) error {
+	min, max, ok := algorithm.LevelRange()
+	if ok && (level < min || level > max) {
+		return fmt.Errorf("%w: %s supports levels %d-%d, got %d", ErrInvalidLevel, algorithm, min, max, level)
+	}
+
+	return nil
+}
+
+// Compressor compresses data with a fixed algorithm and level. Output is
+// prefixed with the algorithm so Decompress does not need to know how the
+// data was compressed.
+type Compressor struct {
+	algorithm Algorithm
+	level     int
+	zstd      *zstd.Encoder
+}
+
+// NewCompressor creates a compressor. Levels follow zstd (1-22); for s2 (1-9),
+// levels below 3 use the default encoder, 3-5 the better encoder and
+// anything higher the best encoder. Levels are not validated so stores
+// created with other levels still open; see ValidateLevel.
+func NewCompressor(algorithm Algorithm, level int) (*Compressor, error) {
+	c := &Compressor{algorithm: algorithm, level: level}
+
+	switch algorithm {
+	case None, S2:
+	case Zstd:
+		enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create zstd encoder: %+v", err)
+		}
+		c.zstd = enc
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAlgorithm, algorithm)
+	}
+
+	return c, nil
+}
+
+func (c *Compressor) Algorithm() Algorithm {
+	return c.algorithm
+}
+
+// Compress compresses data. It returns nil if the compressor does not
+// compress.
+func (c *Compressor) Compress(data []byte) []byte {
+	dst := []byte{byte(c.algorithm)}
+
+	switch c.algorithm {
+	case S2:
+		var encoded []byte
+		switch {
+		case c.level < 3:
+			encoded = s2.Encode(nil, data)
+		case c.level < 6:
+			encoded = s2.EncodeBetter(nil, data)
+		default:
+			encoded = s2.EncodeBest(nil, data)
+		}
+		return append(dst, encoded...)
+	case Zstd:
+		return c.zstd.EncodeAll(data, dst)
+	default:
+		return nil
+	}
+}
+
+// Decompress decompresses data produced by a Compressor
+func Decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: empty input", ErrInvalidAlgorithm)
+	}
+
+	switch Algorithm(data[0]) {
+	case S2:
+		return s2.Decode(nil, data[1:])
+	case Zstd:
+		return decoder.DecodeAll(data[1:], nil)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidAlgorithm, Algorithm(data[0]))
+	}
+}
@@ -0,0 +1,104 @@
+package compress_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/compress"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	data := bytes.Repeat([]byte("2024-10-18T00:00:00Z INFO request handled\n"), 1000)
+
+	cases := []struct {
+		name      string
+		algorithm compress.Algorithm
+		level     int
+	}{
+		{name: "should round trip s2", algorithm: compress.S2, level: 1},
+		{name: "should round trip s2 best", algorithm: compress.S2, level: 9},
+		{name: "should round trip zstd fastest", algorithm: compress.Zstd, level: 1},
+		{name: "should round trip zstd best", algorithm: compress.Zstd, level: 19},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			compressor, err := compress.NewCompressor(c.algorithm, c.level)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			compressed := compressor.Compress(data)
+			if len(compressed) >= len(data) {
+				t.Fatalf("expected compressed len < %d, got %d", len(data), len(compressed))
+			}
+
+			decompressed, err := compress.Decompress(compressed)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(data, decompressed) {
+				t.Fatal("decompressed data does not match original")
+			}
+		})
+	}
+
+	compressor, err := compress.NewCompressor(compress.None, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if compressed := compressor.Compress(data); compressed != nil {
+		t.Fatalf("expected no output for algorithm none, got %d bytes", len(compressed))
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, name := range compress.Algorithms {
+		a, err := compress.ParseAlgorithm(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if a.String() != name {
+			t.Fatalf("expected algorithm %s, got %s", name, a)
+		}
+	}
+
+	_, err := compress.ParseAlgorithm("gzip")
+	if !errors.Is(err, compress.ErrInvalidAlgorithm) {
+		t.Fatalf("expected error %+v, got %+v", compress.ErrInvalidAlgorithm, err)
+	}
+}
+
+func TestValidateLevel(t *testing.T) {
+	cases := []struct {
+		name      string
+		algorithm compress.Algorithm
+		level     int
+		valid     bool
+	}{
+		{name: "should accept zstd fastest", algorithm: compress.Zstd, level: 1, valid: true},
+		{name: "should accept zstd best", algorithm: compress.Zstd, level: 22, valid: true},
+		{name: "should reject zstd zero", algorithm: compress.Zstd, level: 0},
+		{name: "should reject zstd above range", algorithm: compress.Zstd, level: 23},
+		{name: "should accept s2 best", algorithm: compress.S2, level: 9, valid: true},
+		{name: "should reject s2 above range", algorithm: compress.S2, level: 10},
+		{name: "should reject negative s2", algorithm: compress.S2, level: -1},
+		{name: "should ignore level for none", algorithm: compress.None, level: 30, valid: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := compress.ValidateLevel(c.algorithm, c.level)
+			if c.valid && err != nil {
+				t.Fatalf("expected level %d to be valid, got err: %+v", c.level, err)
+			}
+			if !c.valid && !errors.Is(err, compress.ErrInvalidLevel) {
+				t.Fatalf("expected error %+v, got %+v", compress.ErrInvalidLevel, err)
+			}
+		})
+	}
+}
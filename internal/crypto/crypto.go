@@ -7,13 +7,11 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
 
 	pkgerr "github.com/pkg/errors"
 	passwordvalidator "github.com/wagslane/go-password-validator"
 	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/chacha20poly1305"
-	"golang.org/x/term"
 
 	"github.com/julianstephens/warden/internal/warden"
 )
@@ -171,33 +169,3 @@ func validateSaltLen(salt []byte) {
 		panic(pkgerr.Wrap(ErrInvalidSalt, fmt.Sprintf("expected len %d, got %d", saltSize, len(salt))))
 	}
 }
-
-func ReadPassword() (string, error) {
-	state, err := term.MakeRaw(int(os.Stdin.Fd()))
-	if err != nil {
-		panic(os.Stdin.Fd())
-	}
-	defer term.Restore(int(os.Stdin.Fd()), state)
-
-	terminal := term.NewTerminal(os.Stdout, "")
-	pwd, err := terminal.ReadPassword("Enter password for new store: ")
-	if err != nil {
-		return "", &warden.InvalidPasswordError{Msg: err.Error()}
-	}
-
-	if pwd == "" {
-		return "", &warden.InvalidPasswordError{Msg: "password cannot be empty"}
-	}
-
-	confPwd, err := terminal.ReadPassword("Confirm password: ")
-	if err != nil {
-		return "", &warden.InvalidPasswordError{Msg: err.Error()}
-	}
-	term.Restore(int(os.Stdin.Fd()), state)
-
-	if pwd != confPwd {
-		return "", &warden.InvalidPasswordError{Msg: "passwords do not match"}
-	}
-
-	return pwd, nil
-}
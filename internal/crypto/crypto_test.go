@@ -1,18 +1,16 @@
 package crypto_test
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
-	mp "github.com/agiledragon/gomonkey/v2"
-	"golang.org/x/term"
-
 	. "github.com/julianstephens/warden/internal/crypto"
 	"github.com/julianstephens/warden/internal/warden"
-	"github.com/xhd2015/xgo/runtime/mock"
 )
 
 // func assertEqual[T comparable](t *testing.T, expected T, actual T) {
@@ -158,91 +156,149 @@ func TestReadPassword(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	securePassword := string(r)
+	securePassword := fmt.Sprintf("%x", r)
 
 	cases := []struct {
-		name         string
-		input        string
-		confirmation string
-		expected     string
-		gotError     any
+		name     string
+		input    string
+		confirm  bool
+		expected string
+		gotError string
 	}{
 		{
-			name:         "should return password string",
-			input:        securePassword,
-			confirmation: securePassword,
-			expected:     securePassword,
-			gotError:     nil,
+			name:     "should return password string",
+			input:    securePassword + "\n" + securePassword + "\n",
+			confirm:  true,
+			expected: securePassword,
 		},
 		{
-			name:         "should return error when password is empty",
-			input:        "",
-			confirmation: "blah",
-			expected:     "",
-			gotError:     warden.InvalidPasswordError{Msg: "password cannot be empty"},
+			name:     "should only ask once without confirmation",
+			input:    securePassword + "\n",
+			confirm:  false,
+			expected: securePassword,
 		},
 		{
-			name:         "should return error when passwords don't match",
-			input:        securePassword,
-			confirmation: "blah",
-			expected:     "",
-			gotError:     warden.InvalidPasswordError{Msg: "passwords do not match"},
+			name:     "should accept a final line without newline",
+			input:    securePassword,
+			confirm:  false,
+			expected: securePassword,
+		},
+		{
+			name:     "should return error when password is empty",
+			input:    "\nblah\n",
+			confirm:  true,
+			gotError: "password cannot be empty",
+		},
+		{
+			name:     "should return error when passwords don't match",
+			input:    securePassword + "\nblah\n",
+			confirm:  true,
+			gotError: "passwords do not match",
 		},
 	}
 
-	tt := &term.Terminal{}
-	tmpFile, err := os.CreateTemp("", "test")
-	if err != nil {
-		t.Fatal(err)
-	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var out bytes.Buffer
+			prompt := &Prompt{Message: "Enter password: ", In: strings.NewReader(c.input), Out: &out}
+
+			res, err := prompt.ReadPassword(c.confirm)
+			if c.gotError != "" {
+				var pwdErr *warden.InvalidPasswordError
+				if !errors.As(err, &pwdErr) || pwdErr.Msg != c.gotError {
+					t.Fatalf("expected an error: %s, got: %+v", c.gotError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res != c.expected {
+				t.Fatalf("expected %s -> got %s", c.expected, res)
+			}
 
-	mock.Patch(term.MakeRaw, func(fd int) (*term.State, error) {
-		return &term.State{}, nil
-	})
-	mock.Patch(term.Restore, func(fd int, state *term.State) error {
-		return nil
-	})
-	mock.Patch(term.NewTerminal, func(c io.ReadWriter, prompt string) *term.Terminal {
-		return &term.Terminal{}
-	})
+			if !strings.HasPrefix(out.String(), "Enter password: ") {
+				t.Fatalf("expected prompt to be written, got %q", out.String())
+			}
+		})
+	}
+}
 
-	oldStdin := os.Stdin
+func TestPasswordSources(t *testing.T) {
+	const pwd = "testsecurepassword123"
 
-	defer func() {
-		tmpFile.Close()
+	// the directory name must survive as one argument of the password command
+	file := filepath.Join(t.TempDir(), "my vault", "password")
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(file, []byte(pwd+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		os.Stdin = oldStdin
+	// only the first line of a password file is the password
+	multiline := filepath.Join(t.TempDir(), "password")
+	err = os.WriteFile(multiline, []byte(pwd+"\r\n# vault password\nother\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
 
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		w.WriteString(pwd + "\n")
+		w.Close()
 	}()
+	fd := int(r.Fd())
 
-	os.Stdin = tmpFile
+	cases := []struct {
+		name   string
+		source PasswordSource
+	}{
+		{"static", StaticPassword(pwd)},
+		{"file", NewPasswordSource(PasswordOptions{File: file})},
+		{"multi-line file", NewPasswordSource(PasswordOptions{File: multiline})},
+		{"command", NewPasswordSource(PasswordOptions{Command: "echo " + pwd})},
+		{"command with quoted argument", NewPasswordSource(PasswordOptions{Command: `cat "` + file + `"`})},
+		{"command with single quotes", NewPasswordSource(PasswordOptions{Command: `sh -c 'echo "$0"' ` + pwd})},
+		{"file descriptor", NewPasswordSource(PasswordOptions{FD: &fd})},
+	}
 
 	for _, c := range cases {
-
 		t.Run(c.name, func(t *testing.T) {
-			pwdPatch := mp.ApplyMethodSeq(tt, "ReadPassword", []mp.OutputCell{{Values: mp.Params{c.input, nil}}, {Values: mp.Params{c.confirmation, nil}}})
-
-			res, err := ReadPassword()
-			if c.gotError != nil {
-				if !errors.As(err, &c.gotError) {
-					t.Fatalf("expected an error: %+v, got: %+v", c.gotError, err)
-				}
-				return
-			}
+			res, err := c.source.ReadPassword(true)
 			if err != nil {
 				t.Fatal(err)
 			}
 
-			if res != c.expected {
-				t.Fatalf("expected %s -> got %s", c.expected, res)
+			if res != pwd {
+				t.Fatalf("expected %s -> got %s", pwd, res)
 			}
-
-			pwdPatch.Reset()
 		})
 	}
 
-	err = os.Remove(tmpFile.Name())
+	t.Setenv(PasswordEnv, pwd)
+	res, err := NewPasswordSource(PasswordOptions{}).ReadPassword(true)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if res != pwd {
+		t.Fatalf("expected %s -> got %s", pwd, res)
+	}
+
+	_, err = PasswordCommand(`cat "` + file).ReadPassword(false)
+	if err == nil {
+		t.Fatal("expected an unterminated quote in the password command to fail")
+	}
+
+	_, err = StaticPassword("").ReadPassword(false)
+	var pwdErr *warden.InvalidPasswordError
+	if !errors.As(err, &pwdErr) {
+		t.Fatalf("expected invalid password error for empty password, got %+v", err)
+	}
 }
@@ -0,0 +1,243 @@
+package crypto
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+
+	"golang.org/x/term"
+
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+// PasswordEnv is the environment variable read for a store password
+const PasswordEnv = "WARDEN_PASSWORD"
+
+// PasswordSource supplies the password of a store
+type PasswordSource interface {
+	// ReadPassword returns the password. Interactive sources ask twice when
+	// confirm is set, other sources ignore it.
+	ReadPassword(confirm bool) (string, error)
+}
+
+// PasswordOptions selects where a password is read from. The first source set
+// wins, in field order, then WARDEN_PASSWORD, then an interactive prompt.
+type PasswordOptions struct {
+	// File is a file holding the password
+	File string
+	// Command is run and its stdout used as the password. Arguments are
+	// split on whitespace outside of single or double quotes. The command is
+	// not run through a shell.
+	Command string
+	// FD is an inherited file descriptor the password is read from
+	FD *int
+	// Prompt is shown when asking for the password interactively
+	Prompt string
+}
+
+// NewPasswordSource returns the password source selected by opts
+func NewPasswordSource(opts PasswordOptions) PasswordSource {
+	switch {
+	case opts.File != "":
+		return PasswordFile(opts.File)
+	case opts.Command != "":
+		return PasswordCommand(opts.Command)
+	case opts.FD != nil:
+		return PasswordFD(*opts.FD)
+	}
+
+	if pwd, ok := os.LookupEnv(PasswordEnv); ok {
+		return StaticPassword(pwd)
+	}
+
+	return NewPrompt(opts.Prompt)
+}
+
+// StaticPassword is a password known up front
+type StaticPassword string
+
+func (p StaticPassword) ReadPassword(confirm bool) (string, error) {
+	return nonEmpty(string(p))
+}
+
+// PasswordFile reads the password from the first line of a file. Later lines
+// are ignored.
+type PasswordFile string
+
+func (p PasswordFile) ReadPassword(confirm bool) (string, error) {
+	data, err := os.ReadFile(string(p))
+	if err != nil {
+		return "", fmt.Errorf("unable to read password file: %+v", err)
+	}
+
+	line, _, _ := strings.Cut(string(data), "\n")
+	return nonEmpty(trimNewline(line))
+}
+
+// PasswordCommand runs a command and reads the password from its stdout,
+// ignoring a trailing newline
+type PasswordCommand string
+
+func (p PasswordCommand) ReadPassword(confirm bool) (string, error) {
+	args, err := splitArgs(string(p))
+	if err != nil {
+		return "", fmt.Errorf("invalid password command: %+v", err)
+	}
+	if len(args) == 0 {
+		return "", &warden.InvalidPasswordError{Msg: "password command cannot be empty"}
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("unable to run password command: %+v", err)
+	}
+
+	return nonEmpty(trimNewline(string(out)))
+}
+
+// splitArgs splits a command line into arguments on whitespace. Single or
+// double quotes group words into one argument and are removed; a quote can be
+// included inside quotes of the other kind. Backslashes have no special
+// meaning, so Windows paths need no escaping.
+func splitArgs(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+	)
+
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// PasswordFD reads the password from an inherited file descriptor, ignoring a
+// trailing newline
+type PasswordFD int
+
+func (p PasswordFD) ReadPassword(confirm bool) (string, error) {
+	f := os.NewFile(uintptr(p), fmt.Sprintf("fd%d", int(p)))
+	if f == nil {
+		return "", fmt.Errorf("invalid password file descriptor %d", int(p))
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("unable to read password from file descriptor %d: %+v", int(p), err)
+	}
+
+	return nonEmpty(trimNewline(string(data)))
+}
+
+// Prompt asks for a password. Input is hidden when In is a terminal;
+// otherwise one line is read per password.
+type Prompt struct {
+	Message string
+	In      io.Reader
+	Out     io.Writer
+
+	lines *bufio.Reader
+}
+
+// NewPrompt creates a prompt reading from stdin and writing to stderr
+func NewPrompt(message string) *Prompt {
+	if message == "" {
+		message = "Enter password: "
+	}
+
+	return &Prompt{Message: message, In: os.Stdin, Out: os.Stderr}
+}
+
+func (p *Prompt) ReadPassword(confirm bool) (string, error) {
+	pwd, err := p.readLine(p.Message)
+	if err != nil {
+		return "", &warden.InvalidPasswordError{Msg: err.Error()}
+	}
+
+	pwd, err = nonEmpty(pwd)
+	if err != nil || !confirm {
+		return pwd, err
+	}
+
+	confPwd, err := p.readLine("Confirm password: ")
+	if err != nil {
+		return "", &warden.InvalidPasswordError{Msg: err.Error()}
+	}
+
+	if pwd != confPwd {
+		return "", &warden.InvalidPasswordError{Msg: "passwords do not match"}
+	}
+
+	return pwd, nil
+}
+
+func (p *Prompt) readLine(message string) (string, error) {
+	fmt.Fprint(p.Out, message)
+
+	if f, ok := p.In.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		pwd, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(p.Out)
+		return string(pwd), err
+	}
+
+	if p.lines == nil {
+		p.lines = bufio.NewReader(p.In)
+	}
+
+	line, err := p.lines.ReadString('\n')
+	if errors.Is(err, io.EOF) && line != "" {
+		err = nil
+	}
+
+	return trimNewline(line), err
+}
+
+func trimNewline(s string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(s, "\n"), "\r")
+}
+
+func nonEmpty(pwd string) (string, error) {
+	if pwd == "" {
+		return "", &warden.InvalidPasswordError{Msg: "password cannot be empty"}
+	}
+
+	return pwd, nil
+}
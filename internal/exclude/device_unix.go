@@ -0,0 +1,17 @@
+//go:build !windows
+
+package exclude
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+
+	return uint64(stat.Dev), true
+}
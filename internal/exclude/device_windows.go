@@ -0,0 +1,9 @@
+//go:build windows
+
+package exclude
+
+import "io/fs"
+
+func deviceID(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,148 @@
+package exclude_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/exclude"
+)
+
+func TestPattern(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		ignoreCase bool
+		path       string
+		isDir      bool
+		expected   bool
+	}{
+		{"*.log", false, "app.log", false, true},
+		{"*.log", false, "var/log/app.log", false, true},
+		{"*.log", false, "app.log.1", false, false},
+		{"*.LOG", true, "var/app.log", false, true},
+		{"*.LOG", false, "var/app.log", false, false},
+		{"/build", false, "build", true, true},
+		{"/build", false, "src/build", true, false},
+		{"docs/*.md", false, "docs/readme.md", false, true},
+		{"docs/*.md", false, "docs/api/readme.md", false, false},
+		{"docs/*.md", false, "src/docs/readme.md", false, false},
+		{"node_modules/", false, "web/node_modules", true, true},
+		{"node_modules/", false, "web/node_modules", false, false},
+		{"**/tmp", false, "a/b/tmp", true, true},
+		{"**/tmp", false, "tmp", true, true},
+		{"a/**/z", false, "a/z", false, true},
+		{"a/**/z", false, "a/b/c/z", false, true},
+		{"a/**", false, "a/b/c", false, true},
+		{"file?.txt", false, "file1.txt", false, true},
+		{"file?.txt", false, "file10.txt", false, false},
+		{"[!a]*.go", false, "b.go", false, true},
+		{"[!a]*.go", false, "a.go", false, false},
+		{`\#notes`, false, "#notes", false, true},
+	}
+
+	for _, tt := range tests {
+		p, err := exclude.ParsePattern(tt.pattern, tt.ignoreCase)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := p.Match(tt.path, tt.isDir); got != tt.expected {
+			t.Fatalf("expected %q to match %q: %t, got %t", tt.pattern, tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"", "/", "!", "[abc"} {
+		_, err := exclude.ParsePattern(pattern, false)
+		if !errors.Is(err, exclude.ErrInvalidPattern) {
+			t.Fatalf("expected %q to be invalid, got %v", pattern, err)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"keep.txt":                "keep",
+		"debug.log":               "log",
+		"important.log":           "log",
+		"Thumbs.DB":               "thumbs",
+		"cache/CACHEDIR.TAG":      "Signature: 8a477f597d28d172789f06886806bc55\n",
+		"cache/data":              "cached",
+		"fake-cache/CACHEDIR.TAG": "not a cache",
+		"fake-cache/data":         "kept",
+		"private/.nobackup":       "",
+		"private/secret":          "secret",
+		"build/out.bin":           "bin",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	patternFile := filepath.Join(t.TempDir(), "excludes")
+	err := os.WriteFile(patternFile, []byte("# build output\n\n/build/\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := exclude.NewFilter(root, exclude.Options{
+		Patterns:           []string{"*.log", "!important.log"},
+		IgnoreCasePatterns: []string{"thumbs.db"},
+		PatternFiles:       []string{patternFile},
+		ExcludeCaches:      true,
+		IfPresent:          []string{".nobackup"},
+		OneFileSystem:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reasons := map[string]string{}
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+
+		rel, _ := filepath.Rel(root, path)
+		reason, err := filter.Excluded(path, rel, entry)
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+			reasons[filepath.ToSlash(rel)] = reason
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"debug.log": "*.log",
+		"Thumbs.DB": "thumbs.db",
+		"cache":     exclude.CacheDirTag,
+		"private":   "exclude if present .nobackup",
+		"build":     "/build/",
+	}
+	if len(reasons) != len(expected) {
+		t.Fatalf("expected %d excluded paths, got %+v", len(expected), reasons)
+	}
+	for path, reason := range expected {
+		if reasons[path] != reason {
+			t.Fatalf("expected %s to be excluded by %q, got %q", path, reason, reasons[path])
+		}
+	}
+}
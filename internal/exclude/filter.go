@@ -0,0 +1,152 @@
+package exclude
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// CacheDirTag marks a directory as a cache, see https://bford.info/cachedir/
+	CacheDirTag       = "CACHEDIR.TAG"
+	cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+)
+
+type Options struct {
+	// Patterns are gitignore style patterns of paths to skip
+	Patterns []string
+	// IgnoreCasePatterns are like Patterns but match regardless of case
+	IgnoreCasePatterns []string
+	// PatternFiles are files with one pattern per line
+	PatternFiles []string
+	// ExcludeCaches skips directories containing a valid CACHEDIR.TAG
+	ExcludeCaches bool
+	// IfPresent skips directories containing a file with any of these names
+	IfPresent []string
+	// OneFileSystem skips paths on a different file system than the root
+	OneFileSystem bool
+}
+
+// Filter decides which paths under a backup root are skipped
+type Filter struct {
+	patterns      []Pattern
+	excludeCaches bool
+	ifPresent     []string
+	oneFileSystem bool
+	device        uint64
+}
+
+// NewFilter compiles the rules in opts for the backup root
+func NewFilter(root string, opts Options) (*Filter, error) {
+	f := &Filter{
+		excludeCaches: opts.ExcludeCaches,
+		ifPresent:     opts.IfPresent,
+		oneFileSystem: opts.OneFileSystem,
+	}
+
+	patterns := opts.Patterns
+	for _, file := range opts.PatternFiles {
+		filePatterns, err := ReadPatterns(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read exclude file %s: %+v", file, err)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+
+	for _, source := range patterns {
+		p, err := ParsePattern(source, false)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, p)
+	}
+
+	for _, source := range opts.IgnoreCasePatterns {
+		p, err := ParsePattern(source, true)
+		if err != nil {
+			return nil, err
+		}
+		f.patterns = append(f.patterns, p)
+	}
+
+	if f.oneFileSystem {
+		info, err := os.Lstat(root)
+		if err != nil {
+			return nil, err
+		}
+
+		var ok bool
+		f.device, ok = deviceID(info)
+		if !ok {
+			return nil, fmt.Errorf("one file system is not supported on this platform")
+		}
+	}
+
+	return f, nil
+}
+
+// Excluded reports why the entry at path, which is rel relative to the
+// backup root, is skipped. It returns an empty string for included paths.
+func (f *Filter) Excluded(path string, rel string, entry fs.DirEntry) (reason string, err error) {
+	isDir := entry.IsDir()
+	rel = filepath.ToSlash(rel)
+
+	// later patterns override earlier ones, so negations can re-include paths
+	for _, p := range f.patterns {
+		if !p.Match(rel, isDir) {
+			continue
+		}
+
+		if p.negate {
+			reason = ""
+		} else {
+			reason = p.Source
+		}
+	}
+	if reason != "" {
+		return
+	}
+
+	if f.oneFileSystem {
+		var info fs.FileInfo
+		info, err = entry.Info()
+		if err != nil {
+			return
+		}
+
+		if device, ok := deviceID(info); ok && device != f.device {
+			return "one file system", nil
+		}
+	}
+
+	if !isDir {
+		return
+	}
+
+	for _, name := range f.ifPresent {
+		if _, statErr := os.Lstat(filepath.Join(path, name)); statErr == nil {
+			return "exclude if present " + name, nil
+		}
+	}
+
+	if f.excludeCaches && isCacheDir(path) {
+		return CacheDirTag, nil
+	}
+
+	return
+}
+
+func isCacheDir(dir string) bool {
+	f, err := os.Open(filepath.Join(dir, CacheDirTag))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, len(cacheDirSignature))
+	n, _ := f.Read(header)
+
+	return bytes.Equal(header[:n], []byte(cacheDirSignature))
+}
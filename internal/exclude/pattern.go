@@ -0,0 +1,159 @@
+package exclude
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidPattern = errors.New("invalid exclude pattern")
+)
+
+// Pattern is a single gitignore style rule:
+//
+//   - a pattern without a slash matches a name at any depth
+//   - a pattern containing a slash is anchored to the backup root
+//   - a trailing slash only matches directories
+//   - "*" and "?" do not match "/", "**" matches any number of directories
+//   - a leading "!" re-includes paths excluded by an earlier pattern
+type Pattern struct {
+	Source  string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// ParsePattern compiles a pattern. ignoreCase makes it match regardless of
+// case.
+func ParsePattern(source string, ignoreCase bool) (p Pattern, err error) {
+	p.Source = source
+
+	pattern := strings.TrimSpace(source)
+	if strings.HasPrefix(pattern, "!") {
+		p.negate = true
+		pattern = pattern[1:]
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		p.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		err = fmt.Errorf("%w: %q", ErrInvalidPattern, source)
+		return
+	}
+
+	var expr strings.Builder
+	if ignoreCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
+
+	body, err := globToRegexp(pattern)
+	if err != nil {
+		err = fmt.Errorf("%w: %q: %+v", ErrInvalidPattern, source, err)
+		return
+	}
+	expr.WriteString(body)
+	expr.WriteString("$")
+
+	p.re, err = regexp.Compile(expr.String())
+	if err != nil {
+		err = fmt.Errorf("%w: %q: %+v", ErrInvalidPattern, source, err)
+	}
+
+	return
+}
+
+// Match reports whether the pattern matches a slash separated path relative
+// to the backup root
+func (p Pattern) Match(path string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	return p.re.MatchString(path)
+}
+
+func globToRegexp(glob string) (string, error) {
+	var expr strings.Builder
+
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if !strings.HasPrefix(glob[i:], "**") {
+				expr.WriteString("[^/]*")
+				continue
+			}
+
+			atStart := i == 0 || glob[i-1] == '/'
+			switch {
+			case atStart && strings.HasPrefix(glob[i:], "**/"):
+				expr.WriteString("(?:.*/)?")
+				i += 2
+			case atStart && i+2 == len(glob):
+				expr.WriteString(".*")
+				i++
+			default:
+				expr.WriteString("[^/]*")
+				i++
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return "", errors.New("unterminated character class")
+			}
+
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	return expr.String(), nil
+}
+
+// ReadPatterns reads one pattern per line from a file, skipping blank lines
+// and lines starting with "#"
+func ReadPatterns(filename string) (patterns []string, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	err = scanner.Err()
+	return
+}
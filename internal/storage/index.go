@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"sync"
+)
+
+// ChunkLoc locates an encrypted chunk within a pack
+type ChunkLoc struct {
+	Chunk string   `json:"chunk"`
+	Pack  string   `json:"pack"`
+	Type  BlobType `json:"type"`
+
+	ChunkStart int64 `json:"chunkStartOffset"`
+	ChunkEnd   int64 `json:"chunkEndOffset"`
+}
+
+// IndexFile is the decrypted content of a single index file in the store
+type IndexFile struct {
+	Chunks []ChunkLoc `json:"chunks"`
+}
+
+// Index maps chunk HMACs to their location in the store. It is built from
+// every index file in the store, plus chunks written during the current
+// session that have not been saved to an index file yet.
+type Index struct {
+	mu sync.RWMutex
+
+	chunks  map[string]ChunkLoc
+	pending map[string]struct{}
+	unsaved []ChunkLoc
+	files   map[string]struct{}
+}
+
+func NewIndex() *Index {
+	return &Index{
+		chunks:  make(map[string]ChunkLoc),
+		pending: make(map[string]struct{}),
+		files:   make(map[string]struct{}),
+	}
+}
+
+// Length returns the encrypted length of the chunk
+func (c ChunkLoc) Length() int64 {
+	return c.ChunkEnd - c.ChunkStart
+}
+
+// HeaderEntry returns the pack header entry for the chunk
+func (c ChunkLoc) HeaderEntry() HeaderEntry {
+	return HeaderEntry{ID: c.Chunk, Type: c.Type, Offset: c.ChunkStart, Length: c.Length()}
+}
+
+// Lookup returns the location of a stored chunk
+func (i *Index) Lookup(id string) (ChunkLoc, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	loc, ok := i.chunks[id]
+	return loc, ok
+}
+
+// Has reports whether a chunk is stored or queued to be stored
+func (i *Index) Has(id string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	_, ok := i.chunks[id]
+	if !ok {
+		_, ok = i.pending[id]
+	}
+	return ok
+}
+
+// Len returns the number of stored chunks
+func (i *Index) Len() int {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return len(i.chunks)
+}
+
+// AddPending marks a chunk as queued for storage. It returns false if the
+// chunk is already stored or queued, in which case it should be skipped.
+func (i *Index) AddPending(id string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if _, ok := i.chunks[id]; ok {
+		return false
+	}
+	if _, ok := i.pending[id]; ok {
+		return false
+	}
+
+	i.pending[id] = struct{}{}
+	return true
+}
+
+// DropPending forgets all queued chunks, e.g. after a failed backup
+func (i *Index) DropPending() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.pending = make(map[string]struct{})
+}
+
+// AddPack records every blob in a saved pack
+func (i *Index) AddPack(packID string, header Header) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	for _, b := range header.Blobs {
+		loc := ChunkLoc{
+			Chunk:      b.ID,
+			Pack:       packID,
+			Type:       b.Type,
+			ChunkStart: b.Offset,
+			ChunkEnd:   b.Offset + b.Length,
+		}
+
+		delete(i.pending, b.ID)
+		if _, ok := i.chunks[b.ID]; ok {
+			continue
+		}
+
+		i.chunks[b.ID] = loc
+		i.unsaved = append(i.unsaved, loc)
+	}
+}
+
+// Loaded reports whether an index file has already been merged
+func (i *Index) Loaded(name string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	_, ok := i.files[name]
+	return ok
+}
+
+// Merge adds the contents of an index file
+func (i *Index) Merge(name string, file IndexFile) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.files[name] = struct{}{}
+	for _, c := range file.Chunks {
+		if _, ok := i.chunks[c.Chunk]; !ok {
+			i.chunks[c.Chunk] = c
+		}
+	}
+}
+
+// Unsaved returns the chunks added since the last index file was written
+func (i *Index) Unsaved() IndexFile {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return IndexFile{Chunks: append([]ChunkLoc(nil), i.unsaved...)}
+}
+
+// MarkSaved records that the unsaved chunks were written to an index file
+func (i *Index) MarkSaved(name string, file IndexFile) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.files[name] = struct{}{}
+	i.unsaved = i.unsaved[len(file.Chunks):]
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var (
+	ErrUnsupportedNode = errors.New("unsupported node type")
+)
+
+// NewPathMetadata describes the node at path, which is rel relative to the
+// backup volume. Chunks are left for the caller to fill in.
+func NewPathMetadata(path string, rel string, info fs.FileInfo) (meta PathMetadata, err error) {
+	meta = PathMetadata{
+		Path:       filepath.ToSlash(rel),
+		FilePerm:   fmt.Sprintf("%04o", unixPerm(info.Mode())),
+		ModifiedAt: info.ModTime(),
+	}
+
+	mode := info.Mode()
+	switch {
+	case mode.IsRegular():
+		meta.Type = NodeFile
+		meta.FileSize = info.Size()
+	case mode.IsDir():
+		meta.Type = NodeDir
+	case mode&fs.ModeSymlink != 0:
+		meta.Type = NodeSymlink
+		meta.LinkTarget, err = os.Readlink(path)
+		if err != nil {
+			return
+		}
+	case mode&fs.ModeCharDevice != 0:
+		meta.Type = NodeCharDevice
+	case mode&fs.ModeDevice != 0:
+		meta.Type = NodeDevice
+	case mode&fs.ModeNamedPipe != 0:
+		meta.Type = NodeFIFO
+	case mode&fs.ModeSocket != 0:
+		meta.Type = NodeSocket
+	default:
+		err = fmt.Errorf("%w: %s", ErrUnsupportedNode, mode.Type())
+		return
+	}
+
+	err = fillSysMetadata(&meta, path, info)
+	return
+}
+
+// IsFile reports whether the node is a regular file with content
+func (m PathMetadata) IsFile() bool {
+	return m.Type == NodeFile || m.Type == ""
+}
+
+// Perm returns the permission, setuid, setgid and sticky bits of the node
+func (m PathMetadata) Perm() (os.FileMode, error) {
+	bits, err := strconv.ParseUint(m.FilePerm, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file permission %q: %+v", m.FilePerm, err)
+	}
+
+	mode := os.FileMode(bits) & os.ModePerm
+	if bits&0o4000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if bits&0o2000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if bits&0o1000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	return mode, nil
+}
+
+// Mode returns the permission bits of the node together with its type bits
+func (m PathMetadata) Mode() (os.FileMode, error) {
+	mode, err := m.Perm()
+	if err != nil {
+		return 0, err
+	}
+
+	switch m.Type {
+	case NodeDir:
+		mode |= os.ModeDir
+	case NodeSymlink:
+		mode |= os.ModeSymlink
+	case NodeDevice:
+		mode |= os.ModeDevice
+	case NodeCharDevice:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case NodeFIFO:
+		mode |= os.ModeNamedPipe
+	case NodeSocket:
+		mode |= os.ModeSocket
+	}
+
+	return mode, nil
+}
+
+// DeviceMajor returns the major number of a device node. Device numbers are
+// recorded in the Linux encoding.
+func (m PathMetadata) DeviceMajor() uint32 {
+	return uint32(((m.DeviceNumber >> 8) & 0xfff) | ((m.DeviceNumber >> 32) &^ 0xfff))
+}
+
+// DeviceMinor returns the minor number of a device node
+func (m PathMetadata) DeviceMinor() uint32 {
+	return uint32((m.DeviceNumber & 0xff) | ((m.DeviceNumber >> 12) &^ 0xff))
+}
+
+// MakeNode creates a device, FIFO or socket node at path
+func MakeNode(path string, m PathMetadata) error {
+	switch m.Type {
+	case NodeDevice, NodeCharDevice, NodeFIFO, NodeSocket:
+		return makeNode(path, m)
+	default:
+		return fmt.Errorf("%w: cannot make %s node", ErrUnsupportedNode, m.Type)
+	}
+}
+
+// RestoreMetadata applies the ownership, extended attributes, permissions and
+// times of a node to path. Ownership and attributes the current user may not
+// set are skipped. Change times cannot be set and are only recorded.
+func RestoreMetadata(path string, m PathMetadata) error {
+	err := restoreOwner(path, m)
+	if err != nil {
+		return fmt.Errorf("unable to restore owner: %+v", err)
+	}
+
+	err = restoreXattrs(path, m.Xattrs)
+	if err != nil {
+		return fmt.Errorf("unable to restore extended attributes: %+v", err)
+	}
+
+	// symlink permissions are ignored on every supported platform
+	if m.Type != NodeSymlink {
+		perm, err := m.Perm()
+		if err != nil {
+			return err
+		}
+
+		err = os.Chmod(path, perm)
+		if err != nil {
+			return err
+		}
+	}
+
+	return restoreTimes(path, m)
+}
+
+func unixPerm(mode fs.FileMode) uint32 {
+	perm := uint32(mode.Perm())
+	if mode&fs.ModeSetuid != 0 {
+		perm |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		perm |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		perm |= 0o1000
+	}
+
+	return perm
+}
+
+func (m PathMetadata) hasOwner() bool {
+	return m.User != "" || m.Group != "" || m.UID != 0 || m.GID != 0
+}
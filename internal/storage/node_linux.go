@@ -0,0 +1,197 @@
+//go:build linux
+
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+var (
+	userNames  sync.Map
+	groupNames sync.Map
+)
+
+func fillSysMetadata(meta *PathMetadata, path string, info fs.FileInfo) (err error) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+
+	meta.UID = stat.Uid
+	meta.GID = stat.Gid
+	meta.User = lookupName(&userNames, stat.Uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+	meta.Group = lookupName(&groupNames, stat.Gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+	meta.AccessedAt = time.Unix(stat.Atim.Unix())
+	meta.ChangedAt = time.Unix(stat.Ctim.Unix())
+
+	if meta.Type != NodeDir && uint64(stat.Nlink) > 1 {
+		meta.Device = uint64(stat.Dev)
+		meta.Inode = stat.Ino
+		meta.Links = uint64(stat.Nlink)
+	}
+
+	if meta.Type == NodeDevice || meta.Type == NodeCharDevice {
+		meta.DeviceNumber = uint64(stat.Rdev)
+	}
+
+	meta.Xattrs, err = readXattrs(path)
+	return
+}
+
+func lookupName(cache *sync.Map, id uint32, lookup func(id string) (string, error)) string {
+	if name, ok := cache.Load(id); ok {
+		return name.(string)
+	}
+
+	name, err := lookup(strconv.FormatUint(uint64(id), 10))
+	if err != nil {
+		name = ""
+	}
+	cache.Store(id, name)
+
+	return name
+}
+
+func readXattrs(path string) (xattrs []Xattr, err error) {
+	names, err := readXattrBuf(func(buf []byte) (int, error) {
+		return unix.Llistxattr(path, buf)
+	})
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || len(names) == 0 {
+		return
+	}
+
+	list := strings.Split(strings.TrimRight(string(names), "\x00"), "\x00")
+	sort.Strings(list)
+
+	for _, name := range list {
+		var value []byte
+		value, err = readXattrBuf(func(buf []byte) (int, error) {
+			return unix.Lgetxattr(path, name, buf)
+		})
+		// attributes can disappear between listing and reading them
+		if errors.Is(err, unix.ENODATA) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs = append(xattrs, Xattr{Name: name, Value: value})
+	}
+
+	return xattrs, nil
+}
+
+// readXattrBuf sizes a buffer for an xattr syscall, retrying if the value
+// grows in between
+func readXattrBuf(read func(buf []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+
+		buf := make([]byte, size)
+		size, err = read(buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:size], nil
+	}
+}
+
+func restoreOwner(path string, m PathMetadata) error {
+	if !m.hasOwner() {
+		return nil
+	}
+
+	err := os.Lchown(path, int(m.UID), int(m.GID))
+	if errors.Is(err, unix.EPERM) && os.Geteuid() != 0 {
+		warden.Log.Debug().Msgf("not permitted to change owner of %s. skipping...", path)
+		return nil
+	}
+
+	return err
+}
+
+func restoreXattrs(path string, xattrs []Xattr) error {
+	for _, x := range xattrs {
+		err := unix.Lsetxattr(path, x.Name, x.Value, 0)
+		if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+			warden.Log.Debug().Msgf("unable to set attribute %s on %s: %+v. skipping...", x.Name, path, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func restoreTimes(path string, m PathMetadata) error {
+	atime := m.AccessedAt
+	if atime.IsZero() {
+		atime = m.ModifiedAt
+	}
+
+	times := []unix.Timespec{
+		unix.NsecToTimespec(atime.UnixNano()),
+		unix.NsecToTimespec(m.ModifiedAt.UnixNano()),
+	}
+
+	return unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW)
+}
+
+func makeNode(path string, m PathMetadata) error {
+	perm, err := m.Perm()
+	if err != nil {
+		return err
+	}
+
+	mode := uint32(perm.Perm())
+	switch m.Type {
+	case NodeDevice:
+		mode |= unix.S_IFBLK
+	case NodeCharDevice:
+		mode |= unix.S_IFCHR
+	case NodeFIFO:
+		mode |= unix.S_IFIFO
+	case NodeSocket:
+		mode |= unix.S_IFSOCK
+	}
+
+	return unix.Mknod(path, mode, int(m.DeviceNumber))
+}
@@ -0,0 +1,47 @@
+//go:build !linux
+
+package storage
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+// Ownership, extended attributes and device nodes are only captured and
+// restored on Linux. Elsewhere a node keeps its type, permissions, times and
+// symlink target.
+
+func fillSysMetadata(meta *PathMetadata, path string, info fs.FileInfo) error {
+	return nil
+}
+
+func restoreOwner(path string, m PathMetadata) error {
+	return nil
+}
+
+func restoreXattrs(path string, xattrs []Xattr) error {
+	if len(xattrs) > 0 {
+		warden.Log.Debug().Msgf("extended attributes are not supported on this platform. skipping %d for %s...", len(xattrs), path)
+	}
+	return nil
+}
+
+func restoreTimes(path string, m PathMetadata) error {
+	if m.Type == NodeSymlink {
+		return nil
+	}
+
+	atime := m.AccessedAt
+	if atime.IsZero() {
+		atime = m.ModifiedAt
+	}
+
+	return os.Chtimes(path, atime, m.ModifiedAt)
+}
+
+func makeNode(path string, m PathMetadata) error {
+	return fmt.Errorf("%w: %s nodes are not supported on this platform", ErrUnsupportedNode, m.Type)
+}
@@ -1,24 +1,238 @@
 package storage
 
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/alecthomas/units"
+
+	"github.com/julianstephens/warden/internal/compress"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
 type BlobType int
 
 const (
-	Data int = 1 << iota
+	Data BlobType = 1 << iota
 	CompressedData
 )
 
+const (
+	// DefaultPackSize is the size a pack grows to before it is written
+	DefaultPackSize = 16 * units.MiB
+
+	headerLenSize = 4
+)
+
+var (
+	ErrInvalidPack = errors.New("invalid pack")
+)
+
 type Blob struct {
-	Data []byte
+	ID   string
 	Type BlobType
+	Data []byte
 }
 
+// HeaderEntry locates a single encrypted blob within a pack
+type HeaderEntry struct {
+	ID     string   `json:"id"`
+	Type   BlobType `json:"type"`
+	Offset int64    `json:"offset"`
+	Length int64    `json:"length"`
+}
+
+// Header lists every blob in a pack. It is stored encrypted at the end of the
+// pack, followed by its length as a little endian uint32.
 type Header struct {
-	Key  []byte
-	Data []byte
-	Len  int
+	Blobs []HeaderEntry `json:"blobs"`
 }
 
 type Pack struct {
-	Data   []Blob
+	ID     warden.ID
+	Data   []byte
 	Header Header
 }
+
+// Packer assembles compressed and encrypted blobs into packs
+type Packer struct {
+	key        crypto.Key
+	compressor *compress.Compressor
+	targetSize int
+	buf        bytes.Buffer
+	header     Header
+}
+
+// NewPacker creates a packer. A nil compressor stores every blob raw.
+func NewPacker(key crypto.Key, compressor *compress.Compressor, targetSize int) *Packer {
+	if targetSize <= 0 {
+		targetSize = int(DefaultPackSize)
+	}
+
+	return &Packer{key: key, compressor: compressor, targetSize: targetSize}
+}
+
+// Add compresses and encrypts a chunk and appends it to the pack
+func (p *Packer) Add(id string, data []byte) error {
+	blob, err := p.Seal(id, data)
+	if err != nil {
+		return err
+	}
+
+	p.AddBlob(blob)
+	return nil
+}
+
+// Seal compresses and encrypts a chunk without adding it to the pack. Chunks
+// that do not shrink when compressed are stored raw. Seal is safe to call
+// concurrently, so chunks can be sealed in parallel and added in order.
+func (p *Packer) Seal(id string, data []byte) (Blob, error) {
+	blob := Blob{ID: id, Type: Data, Data: data}
+
+	var compressed []byte
+	if p.compressor != nil {
+		compressed = p.compressor.Compress(data)
+	}
+	if compressed != nil && len(compressed) < len(data) {
+		blob.Type = CompressedData
+		blob.Data = compressed
+	}
+
+	ad := []byte(id)
+	encrypted, err := crypto.Encrypt(p.key, blob.Data, &ad)
+	if err != nil {
+		return Blob{}, fmt.Errorf("unable to encrypt blob %s: %+v", id, err)
+	}
+	blob.Data = encrypted
+
+	return blob, nil
+}
+
+// AddBlob appends a sealed blob to the pack
+func (p *Packer) AddBlob(blob Blob) {
+	p.header.Blobs = append(p.header.Blobs, HeaderEntry{
+		ID:     blob.ID,
+		Type:   blob.Type,
+		Offset: int64(p.buf.Len()),
+		Length: int64(len(blob.Data)),
+	})
+	p.buf.Write(blob.Data)
+}
+
+// Count returns the number of blobs in the pack
+func (p *Packer) Count() int {
+	return len(p.header.Blobs)
+}
+
+// Size returns the number of blob bytes in the pack
+func (p *Packer) Size() int {
+	return p.buf.Len()
+}
+
+// Full reports whether the pack has reached its target size
+func (p *Packer) Full() bool {
+	return p.buf.Len() >= p.targetSize
+}
+
+// Finalize appends the encrypted header and returns the completed pack. The
+// packer is reset and can be reused afterwards.
+func (p *Packer) Finalize() (*Pack, error) {
+	headerJson, err := json.Marshal(p.header)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal pack header: %+v", err)
+	}
+
+	encHeader, err := crypto.Encrypt(p.key, headerJson, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encrypt pack header: %+v", err)
+	}
+
+	p.buf.Write(encHeader)
+	err = binary.Write(&p.buf, binary.LittleEndian, uint32(len(encHeader)))
+	if err != nil {
+		return nil, err
+	}
+
+	data := bytes.Clone(p.buf.Bytes())
+	pack := &Pack{
+		ID:     crypto.Hash(data),
+		Data:   data,
+		Header: p.header,
+	}
+
+	p.buf.Reset()
+	p.header = Header{}
+
+	return pack, nil
+}
+
+// HeaderLengthSize is the size of the header length stored at the end of a
+// pack
+const HeaderLengthSize = headerLenSize
+
+// ReadHeaderLength decodes the header length stored in the last
+// HeaderLengthSize bytes of a pack
+func ReadHeaderLength(trailer []byte) (int64, error) {
+	if len(trailer) != headerLenSize {
+		return 0, fmt.Errorf("%w: expected %d byte header length, got %d", ErrInvalidPack, headerLenSize, len(trailer))
+	}
+
+	return int64(binary.LittleEndian.Uint32(trailer)), nil
+}
+
+// ReadHeader decrypts the header at the end of a pack
+func ReadHeader(key crypto.Key, pack []byte) (header Header, err error) {
+	if len(pack) < headerLenSize {
+		err = fmt.Errorf("%w: too short", ErrInvalidPack)
+		return
+	}
+
+	headerLen := int(binary.LittleEndian.Uint32(pack[len(pack)-headerLenSize:]))
+	headerEnd := len(pack) - headerLenSize
+	if headerLen > headerEnd {
+		err = fmt.Errorf("%w: header length %d exceeds pack size", ErrInvalidPack, headerLen)
+		return
+	}
+
+	headerJson, err := crypto.Decrypt(key, pack[headerEnd-headerLen:headerEnd], nil)
+	if err != nil {
+		err = fmt.Errorf("%w: unable to decrypt header: %+v", ErrInvalidPack, err)
+		return
+	}
+
+	err = json.Unmarshal(headerJson, &header)
+	return
+}
+
+// OpenBlob decrypts and, if needed, decompresses a blob read from a pack
+func OpenBlob(key crypto.Key, entry HeaderEntry, data []byte) ([]byte, error) {
+	ad := []byte(entry.ID)
+	decrypted, err := crypto.Decrypt(key, data, &ad)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt blob %s: %+v", entry.ID, err)
+	}
+
+	if entry.Type&CompressedData == 0 {
+		return decrypted, nil
+	}
+
+	decompressed, err := compress.Decompress(decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress blob %s: %+v", entry.ID, err)
+	}
+
+	return decompressed, nil
+}
+
+// Blob returns the decrypted contents of a blob in the pack
+func (p *Pack) Blob(key crypto.Key, entry HeaderEntry) ([]byte, error) {
+	if entry.Offset < 0 || entry.Offset+entry.Length > int64(len(p.Data)) {
+		return nil, fmt.Errorf("%w: blob %s out of range", ErrInvalidPack, entry.ID)
+	}
+
+	return OpenBlob(key, entry, p.Data[entry.Offset:entry.Offset+entry.Length])
+}
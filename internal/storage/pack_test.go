@@ -0,0 +1,121 @@
+package storage_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/compress"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
+)
+
+func TestPacker(t *testing.T) {
+	key, err := crypto.NewSessionKey(crypto.NewSalt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	random, err := crypto.NewRandom(4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := map[string][]byte{
+		"text":   bytes.Repeat([]byte("abc"), 4096),
+		"random": random,
+	}
+
+	compressor, err := compress.NewCompressor(compress.Zstd, compress.DefaultLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packer := storage.NewPacker(*key, compressor, 4096)
+	for _, id := range []string{"text", "random"} {
+		if err := packer.Add(id, blobs[id]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !packer.Full() {
+		t.Fatalf("expected pack of %d bytes to be full", packer.Size())
+	}
+
+	pack, err := packer.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if packer.Count() != 0 {
+		t.Fatalf("expected packer to reset after finalize, got %d blobs", packer.Count())
+	}
+
+	header, err := storage.ReadHeader(*key, pack.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedTypes := map[string]storage.BlobType{"text": storage.CompressedData, "random": storage.Data}
+	for _, entry := range header.Blobs {
+		if entry.Type != expectedTypes[entry.ID] {
+			t.Fatalf("expected blob %s to have type %d, got %d", entry.ID, expectedTypes[entry.ID], entry.Type)
+		}
+
+		data, err := pack.Blob(*key, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, blobs[entry.ID]) {
+			t.Fatalf("blob %s does not match original data", entry.ID)
+		}
+	}
+
+	otherKey, err := crypto.NewSessionKey(crypto.NewSalt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = storage.ReadHeader(*otherKey, pack.Data)
+	if !errors.Is(err, storage.ErrInvalidPack) {
+		t.Fatalf("expected error %+v, got %+v", storage.ErrInvalidPack, err)
+	}
+}
+
+func TestPackerWithoutCompression(t *testing.T) {
+	key, err := crypto.NewSessionKey(crypto.NewSalt())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressor, err := compress.NewCompressor(compress.None, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("abc"), 4096)
+	packer := storage.NewPacker(*key, compressor, 0)
+	if err := packer.Add("text", data); err != nil {
+		t.Fatal(err)
+	}
+
+	pack, err := packer.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := pack.Header.Blobs[0]
+	if entry.Type != storage.Data {
+		t.Fatalf("expected raw blob type %d, got %d", storage.Data, entry.Type)
+	}
+
+	blob, err := pack.Blob(*key, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(blob, data) {
+		t.Fatal("blob does not match original data")
+	}
+}
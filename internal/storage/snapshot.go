@@ -4,30 +4,82 @@ import (
 	"time"
 )
 
+type NodeType string
+
+const (
+	NodeFile       NodeType = "file"
+	NodeDir        NodeType = "dir"
+	NodeSymlink    NodeType = "symlink"
+	NodeDevice     NodeType = "dev"
+	NodeCharDevice NodeType = "chardev"
+	NodeFIFO       NodeType = "fifo"
+	NodeSocket     NodeType = "socket"
+)
+
+// Xattr is an extended attribute. POSIX ACLs are stored as the
+// system.posix_acl_access and system.posix_acl_default attributes.
+type Xattr struct {
+	Name  string `json:"name"`
+	Value []byte `json:"value"`
+}
+
 type PathMetadata struct {
-	Path       string    `json:"path"`
-	FileSize   int64     `json:"fileSize"`
-	FilePerm   string    `json:"filePermission"`
+	// Path is relative to the snapshot's backup volume and slash separated
+	Path string `json:"path"`
+	// Type is empty in snapshots written before node types were recorded,
+	// which only hold regular files
+	Type     NodeType `json:"type,omitempty"`
+	FileSize int64    `json:"fileSize"`
+	// FilePerm holds the permission, setuid, setgid and sticky bits in octal
+	FilePerm string `json:"filePermission"`
+
+	UID   uint32 `json:"uid,omitempty"`
+	GID   uint32 `json:"gid,omitempty"`
+	User  string `json:"user,omitempty"`
+	Group string `json:"group,omitempty"`
+
 	ModifiedAt time.Time `json:"modifiedAt"`
+	AccessedAt time.Time `json:"accessedAt"`
+	ChangedAt  time.Time `json:"changedAt"`
 
-	Chunks []string `json:"chunks"`
-}
+	// LinkTarget is the target of a symlink
+	LinkTarget string `json:"linkTarget,omitempty"`
+	// Device, Inode and Links group hardlinked files. They are only set for
+	// files with more than one link.
+	Device uint64 `json:"device,omitempty"`
+	Inode  uint64 `json:"inode,omitempty"`
+	Links  uint64 `json:"links,omitempty"`
+	// DeviceNumber is the major and minor number of a device node
+	DeviceNumber uint64 `json:"deviceNumber,omitempty"`
 
-type ChunkLoc struct {
-	Chunk string `json:"chunk"`
-	Pack  string `json:"pack"`
+	Xattrs []Xattr `json:"xattrs,omitempty"`
 
-	ChunkStart int64 `json:"chunkStartOffset"`
-	ChunkEnd   int64 `json:"chunkEndOffset"`
+	Chunks []string `json:"chunks"`
 }
 
 type Snapshot struct {
+	// ID is the hash of the encrypted snapshot and is not stored in it
+	ID     string `json:"-"`
+	Parent string `json:"parent,omitempty"`
+
 	BackupVolume string         `json:"backupVolume"`
 	Paths        []PathMetadata `json:"paths"`
-
-	ChunkLocs []ChunkLoc `json:"chunkLocations"`
+	Tags         []string       `json:"tags,omitempty"`
 
 	CreatedAt time.Time `json:"createdAt"`
 	Hostname  string    `json:"hostname"`
 	Username  string    `json:"username"`
 }
+
+// Stats returns the number of regular files in the snapshot and their total
+// size
+func (s Snapshot) Stats() (files int, size int64) {
+	for _, meta := range s.Paths {
+		if meta.IsFile() {
+			files++
+			size += meta.FileSize
+		}
+	}
+
+	return
+}
@@ -2,64 +2,183 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
+	"os/user"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
-	"github.com/julianstephens/warden/internal/chunker"
-	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/backend/common"
 	"github.com/julianstephens/warden/internal/storage"
 	"github.com/julianstephens/warden/internal/warden"
 )
 
-func (s *Store) Backup(ctx context.Context, backupDir string) error {
+// StdinPrefix starts the backup volume of snapshots made by BackupReader
+const StdinPrefix = "stdin:"
+
+var (
+	ErrInvalidFileName = errors.New("invalid file name")
+)
+
+// Backup snapshots backupDir under a shared lock. Files are chunked, sealed
+// and uploaded concurrently within the limits of opts.
+func (s *Store) Backup(ctx context.Context, backupDir string, opts BackupOptions) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	if backupDir == s.Location {
+	backupDir, err := filepath.Abs(backupDir)
+	if err != nil {
+		return fmt.Errorf("unable to resolve backup dir %s: %+v", backupDir, err)
+	}
+
+	if storeLoc, err := filepath.Abs(s.Location); err == nil && backupDir == storeLoc {
 		return fmt.Errorf("cannot backup warden store")
 	}
-	err := backup(s, ctx, backupDir)
+
+	err = s.withLock(ctx, false, func(ctx context.Context) error {
+		return backup(s, ctx, backupDir, opts, func(p *backupPipeline, latest *storage.Snapshot) fileSource {
+			return func(ctx context.Context, files chan<- fileJob) error {
+				return p.walk(ctx, latest, backupDir, files)
+			}
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to backup dir %s: %w", backupDir, err)
+	}
+
+	return nil
+}
+
+// BackupReader snapshots the data read from r as a single file called name,
+// for example a database dump streamed from stdin. The data is chunked and
+// deduplicated like any other file. The snapshot's backup volume is
+// StdinPrefix followed by name.
+func (s *Store) BackupReader(ctx context.Context, r io.Reader, name string, opts BackupOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return fmt.Errorf("%w: %q", ErrInvalidFileName, name)
+	}
+
+	volume := StdinPrefix + name
+	err := s.withLock(ctx, false, func(ctx context.Context) error {
+		return backup(s, ctx, volume, opts, func(p *backupPipeline, latest *storage.Snapshot) fileSource {
+			return func(ctx context.Context, files chan<- fileJob) error {
+				now := time.Now()
+				meta := storage.PathMetadata{
+					Path:       name,
+					Type:       storage.NodeFile,
+					FilePerm:   "0600",
+					ModifiedAt: now,
+					AccessedAt: now,
+					ChangedAt:  now,
+				}
+				return send(ctx, files, fileJob{path: name, reader: r, meta: meta})
+			}
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("unable to backup dir %s: %+v", backupDir, err)
+		return fmt.Errorf("unable to backup %s: %w", name, err)
 	}
 
 	return nil
 }
 
-func backup(store *Store, ctx context.Context, backupDir string) (err error) {
-	latestSnapshot, err := getLastestSnapshot(store, ctx, backupDir)
+// backup runs the backup pipeline on the files queued by the source newSource
+// returns, and saves a snapshot of volume
+func backup(store *Store, ctx context.Context, volume string, opts BackupOptions, newSource func(*backupPipeline, *storage.Snapshot) fileSource) (err error) {
+	latestSnapshot, err := getLastestSnapshot(store, ctx, volume)
 	if err != nil {
-		err = fmt.Errorf("unable to retrieve latest snapshot for backup dir %s: %+v", backupDir, err)
+		err = fmt.Errorf("unable to retrieve latest snapshot for backup volume %s: %+v", volume, err)
 		return
 	}
 
-	// TODO: chunk and hash
-	pathsToBackup, pathsToCopy, err := sortBackupPaths(latestSnapshot, backupDir)
+	defer func() {
+		if err != nil {
+			store.index.DropPending()
+		}
+	}()
+
+	err = store.loadIndex(ctx)
 	if err != nil {
 		return
 	}
 
-	fmt.Println(pathsToBackup)
-	fmt.Println(pathsToCopy)
+	chunkerOpts, err := store.chunkerOptions()
+	if err != nil {
+		return
+	}
 
-	fmt.Printf("%-64s  %s\n", "HASH", "CHUNK SIZE")
-	for _, p := range pathsToBackup {
-		chunkAndHash(store, p)
+	compressor, err := newCompressor(store.conf.Compression)
+	if err != nil {
+		return
 	}
 
+	packer := storage.NewPacker(*store.master.master, compressor, int(storage.DefaultPackSize))
+	pipeline, err := newBackupPipeline(store, packer, chunkerOpts, volume, opts)
+	if err != nil {
+		return
+	}
+
+	paths, err := pipeline.run(ctx, newSource(pipeline, latestSnapshot))
+	if err != nil {
+		return
+	}
+
+	err = store.saveIndex(ctx)
+	if err != nil {
+		return
+	}
+
+	snap, err := newSnapshot(latestSnapshot, volume, paths, opts.Tags)
+	if err != nil {
+		return
+	}
+
+	err = store.saveSnapshot(ctx, snap)
 	return
 }
 
+func newSnapshot(parent *storage.Snapshot, backupDir string, paths []storage.PathMetadata, tags []string) (*storage.Snapshot, error) {
+	username, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get system user: %+v", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get system hostname: %+v", err)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return paths[i].Path < paths[j].Path
+	})
+
+	snap := &storage.Snapshot{
+		BackupVolume: backupDir,
+		Paths:        paths,
+		Tags:         tags,
+		CreatedAt:    time.Now(),
+		Hostname:     hostname,
+		Username:     username.Username,
+	}
+	if parent != nil {
+		snap.Parent = parent.ID
+	}
+
+	return snap, nil
+}
+
 func getLastestSnapshot(store *Store, ctx context.Context, backupDir string) (snap *storage.Snapshot, err error) {
-	snapshots, err := store.backend.ListSnapshots(ctx)
+	snapshots, err := store.ListSnapshots(ctx)
 	if err != nil {
 		return
 	}
-	fmt.Print(len(snapshots))
 
 	backupSnaps := warden.Filter(snapshots, func(t storage.Snapshot) bool {
 		return t.BackupVolume == backupDir
@@ -77,65 +196,15 @@ func getLastestSnapshot(store *Store, ctx context.Context, backupDir string) (sn
 	return
 }
 
-func sortBackupPaths(latestSnapshot *storage.Snapshot, backupDir string) (pathsToBackup []string, pathsToCopy []string, err error) {
-	err = filepath.WalkDir(backupDir, func(path string, entry fs.DirEntry, err error) error {
-		if entry.IsDir() {
-			return nil
-		}
-
-		if latestSnapshot != nil {
-			for _, p := range latestSnapshot.Paths {
-				if p.Path == path {
-					entryInfo, err := entry.Info()
-					if err != nil || p.FileSize != entryInfo.Size() || !p.ModifiedAt.Equal(entryInfo.ModTime()) {
-						pathsToBackup = append(pathsToBackup, path)
-						return nil
-					} else {
-						pathsToCopy = append(pathsToCopy, path)
-						return nil
-					}
-				}
-			}
-		}
-
-		pathsToBackup = append(pathsToBackup, path)
-		return nil
-	})
-
-	return
-}
-
-func chunkAndHash(store *Store, filepath string) (err error) {
-	warden.Log.Debug().Msgf("checking file %s exists...", filepath)
-
-	if _, err = os.Stat(filepath); err == nil {
-		var file *os.File
-		file, err = os.Open(filepath)
-		if err != nil {
-			return
-		}
-		defer file.Close()
-
-		warden.Log.Debug().Msg("chunking and hashing file...")
-		cKr := chunker.NewChunker(file)
-
-		for {
-			var chunk chunker.Chunk
-			chunk, err = cKr.Next()
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return
-			}
-
-			hashedChunk := crypto.SecureHash(chunk.Data, store.master.user.Data)
-			fmt.Println(hashedChunk)
-			// TODO: check if chunk has already been backed up
-		}
-	} else {
-		warden.Log.Info().Msgf("file %s does not exist. skipping...", filepath)
+func savePack(store *Store, ctx context.Context, pack *storage.Pack) error {
+	name := pack.ID.String()
+	warden.Log.Debug().Msgf("saving pack %s with %d blobs...", name, len(pack.Header.Blobs))
+	err := store.backend.Save(ctx, common.Event{Type: common.Pack, Name: &name}, common.NewHashedByteReader(pack.Data, pack.ID[:]))
+	if err != nil {
+		return fmt.Errorf("unable to save pack %s: %+v", name, err)
 	}
+	store.index.AddPack(name, pack.Header)
+	warden.Log.Debug().Msg("pack saved.")
 
-	return
+	return nil
 }
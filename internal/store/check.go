@@ -0,0 +1,452 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"strings"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+var (
+	ErrCheckFailed    = errors.New("check found problems")
+	ErrMissingPack    = errors.New("pack is missing")
+	ErrHeaderMismatch = errors.New("pack header does not match index")
+	ErrContentHash    = errors.New("content does not match its id")
+)
+
+type CheckOptions struct {
+	// ReadData decrypts every blob of every pack and verifies its HMAC
+	ReadData bool
+	// ReadDataSubset reads a random fraction of the packs, between 0 and 1,
+	// when ReadData is unset
+	ReadDataSubset float64
+}
+
+// CheckError is a problem found in a single file of the store
+type CheckError struct {
+	Type common.FileType
+	Name string
+	// Blob is set for problems with a single blob of a pack
+	Blob string
+	Err  error
+}
+
+func (e *CheckError) Error() string {
+	file := strings.ToLower(e.Type.String())
+	if e.Name != "" {
+		file += " " + e.Name
+	}
+	if e.Blob != "" {
+		file += " blob " + e.Blob
+	}
+
+	return fmt.Sprintf("%s: %+v", file, e.Err)
+}
+
+func (e *CheckError) Unwrap() error {
+	return e.Err
+}
+
+// CheckResult summarizes a check. The store is consistent if Errors is empty.
+type CheckResult struct {
+	Keys       int
+	Snapshots  int
+	IndexFiles int
+	Packs      int
+	Blobs      int
+	PacksRead  int
+	BlobsRead  int
+
+	// Unreferenced lists packs that no index file refers to, e.g. after an
+	// interrupted backup. They hold no data snapshots depend on.
+	Unreferenced []string
+	Errors       []*CheckError
+}
+
+// Err returns ErrCheckFailed if the check found problems
+func (r *CheckResult) Err() error {
+	if len(r.Errors) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %d errors", ErrCheckFailed, len(r.Errors))
+}
+
+type checker struct {
+	store  *Store
+	opts   CheckOptions
+	result *CheckResult
+
+	// index maps each pack to the chunks the index files place in it
+	index   map[string][]storage.ChunkLoc
+	chunks  map[string]struct{}
+	headers map[string]storage.Header
+}
+
+// Check verifies that the store is consistent: the config and keyfiles are
+// readable, every file matches its ID, index entries point into existing
+// packs whose headers agree, and every chunk of every snapshot is indexed.
+// With opts.ReadData, blobs are decrypted and checked against their HMAC.
+// Problems are collected in the result; an error is only returned if the
+// check could not run.
+func (s *Store) Check(ctx context.Context, opts CheckOptions) (*CheckResult, error) {
+	c := &checker{
+		store:   s,
+		opts:    opts,
+		result:  &CheckResult{},
+		index:   make(map[string][]storage.ChunkLoc),
+		chunks:  make(map[string]struct{}),
+		headers: make(map[string]storage.Header),
+	}
+
+	steps := []struct {
+		name string
+		run  func(context.Context) error
+	}{
+		{"config", c.checkConfig},
+		{"keys", c.checkKeys},
+		{"index", c.checkIndex},
+		{"packs", c.checkPacks},
+		{"snapshots", c.checkSnapshots},
+		{"data", c.readData},
+	}
+
+	for _, step := range steps {
+		warden.Log.Debug().Msgf("checking %s...", step.name)
+		err := step.run(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return c.result, nil
+}
+
+func (c *checker) fail(t common.FileType, name string, err error) {
+	c.result.Errors = append(c.result.Errors, &CheckError{Type: t, Name: name, Err: err})
+}
+
+// load reads a file and, for files named by their hash, verifies it
+func (c *checker) load(ctx context.Context, t common.FileType, name string, hashed bool) ([]byte, bool) {
+	data, err := c.store.backend.Load(ctx, common.Event{Type: t, Name: &name}, 0, 0)
+	if err != nil {
+		c.fail(t, name, err)
+		return nil, false
+	}
+
+	if hashed && crypto.Hash(data).String() != name {
+		c.fail(t, name, ErrContentHash)
+		return nil, false
+	}
+
+	return data, true
+}
+
+func (c *checker) checkConfig(ctx context.Context) error {
+	data, err := c.store.backend.Load(ctx, common.Event{Type: common.Config}, 0, 0)
+	if err != nil {
+		c.fail(common.Config, "", err)
+		return nil
+	}
+
+	var config warden.Config
+	err = json.Unmarshal(data, &config)
+	if err != nil {
+		c.fail(common.Config, "", fmt.Errorf("unable to parse config: %+v", err))
+		return nil
+	}
+
+	if len(config.Secret) > 0 {
+		_, err = crypto.Decrypt(*c.store.master.master, config.Secret, nil)
+		if err != nil {
+			c.fail(common.Config, "", fmt.Errorf("unable to decrypt config secret: %+v", err))
+		}
+	}
+
+	return nil
+}
+
+// checkKeys parses every keyfile. Only the key that opened the store can be
+// decrypted, which happened when it was opened.
+func (c *checker) checkKeys(ctx context.Context) error {
+	names, err := c.store.backend.List(ctx, common.Key)
+	if err != nil {
+		return fmt.Errorf("unable to list keys: %+v", err)
+	}
+
+	for _, name := range names {
+		c.result.Keys++
+
+		data, ok := c.load(ctx, common.Key, name, true)
+		if !ok {
+			continue
+		}
+
+		var k Key
+		err = json.Unmarshal(data, &k)
+		switch {
+		case err != nil:
+			c.fail(common.Key, name, fmt.Errorf("unable to parse key: %+v", err))
+		case k.Version > keyVersion:
+			c.fail(common.Key, name, fmt.Errorf("%w: %d", ErrUnsupportedKeyVersion, k.Version))
+		case len(k.Salt) == 0 || len(k.Data) == 0:
+			c.fail(common.Key, name, errors.New("malformed key: missing salt or data"))
+		}
+	}
+
+	return nil
+}
+
+// checkIndex reads every index file, independent of the index loaded when
+// the store was opened
+func (c *checker) checkIndex(ctx context.Context) error {
+	names, err := c.store.backend.List(ctx, common.Index)
+	if err != nil {
+		return fmt.Errorf("unable to list index files: %+v", err)
+	}
+
+	for _, name := range names {
+		c.result.IndexFiles++
+
+		data, ok := c.load(ctx, common.Index, name, true)
+		if !ok {
+			continue
+		}
+
+		decrypted, err := crypto.Decrypt(*c.store.master.master, data, nil)
+		if err != nil {
+			c.fail(common.Index, name, fmt.Errorf("unable to decrypt index file: %+v", err))
+			continue
+		}
+
+		var file storage.IndexFile
+		err = json.Unmarshal(decrypted, &file)
+		if err != nil {
+			c.fail(common.Index, name, fmt.Errorf("unable to parse index file: %+v", err))
+			continue
+		}
+
+		for _, loc := range file.Chunks {
+			c.index[loc.Pack] = append(c.index[loc.Pack], loc)
+			c.chunks[loc.Chunk] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// checkPacks reads the header of every pack and compares it with the index
+func (c *checker) checkPacks(ctx context.Context) error {
+	names, err := c.store.backend.List(ctx, common.Pack)
+	if err != nil {
+		return fmt.Errorf("unable to list packs: %+v", err)
+	}
+	sort.Strings(names)
+
+	listed := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		listed[name] = struct{}{}
+		c.result.Packs++
+
+		header, err := c.readHeader(ctx, name)
+		if err != nil {
+			c.fail(common.Pack, name, err)
+			continue
+		}
+		c.headers[name] = header
+		c.result.Blobs += len(header.Blobs)
+
+		locs, ok := c.index[name]
+		if !ok {
+			c.result.Unreferenced = append(c.result.Unreferenced, name)
+			continue
+		}
+
+		entries := make(map[string]storage.HeaderEntry, len(header.Blobs))
+		for _, entry := range header.Blobs {
+			entries[entry.ID] = entry
+		}
+
+		for _, loc := range locs {
+			if entry, ok := entries[loc.Chunk]; !ok || entry != loc.HeaderEntry() {
+				c.fail(common.Pack, name, fmt.Errorf("%w: chunk %s", ErrHeaderMismatch, loc.Chunk))
+			}
+		}
+	}
+
+	var missing []string
+	for pack := range c.index {
+		if _, ok := listed[pack]; !ok {
+			missing = append(missing, pack)
+		}
+	}
+	sort.Strings(missing)
+	for _, pack := range missing {
+		c.fail(common.Pack, pack, ErrMissingPack)
+	}
+
+	return nil
+}
+
+// readHeader loads only the header of a pack and checks that its blobs lie
+// within the pack
+func (c *checker) readHeader(ctx context.Context, name string) (header storage.Header, err error) {
+	event := common.Event{Type: common.Pack, Name: &name}
+
+	info, err := c.store.backend.Stat(ctx, event)
+	if err != nil {
+		return
+	}
+	if info.Size < storage.HeaderLengthSize {
+		err = fmt.Errorf("%w: too short", storage.ErrInvalidPack)
+		return
+	}
+
+	trailer, err := c.store.backend.Load(ctx, event, info.Size-storage.HeaderLengthSize, storage.HeaderLengthSize)
+	if err != nil {
+		return
+	}
+
+	headerLen, err := storage.ReadHeaderLength(trailer)
+	if err != nil {
+		return
+	}
+
+	dataEnd := info.Size - storage.HeaderLengthSize - headerLen
+	if headerLen == 0 || dataEnd < 0 {
+		err = fmt.Errorf("%w: header length %d exceeds pack size", storage.ErrInvalidPack, headerLen)
+		return
+	}
+
+	encHeader, err := c.store.backend.Load(ctx, event, dataEnd, headerLen)
+	if err != nil {
+		return
+	}
+
+	header, err = storage.ReadHeader(*c.store.master.master, append(encHeader, trailer...))
+	if err != nil {
+		return
+	}
+
+	for _, entry := range header.Blobs {
+		if entry.Offset < 0 || entry.Length <= 0 || entry.Offset+entry.Length > dataEnd {
+			err = fmt.Errorf("%w: blob %s out of range", storage.ErrInvalidPack, entry.ID)
+			return
+		}
+	}
+
+	return
+}
+
+// checkSnapshots verifies that every chunk of every snapshot is indexed
+func (c *checker) checkSnapshots(ctx context.Context) error {
+	names, err := c.store.backend.List(ctx, common.Snapshot)
+	if err != nil {
+		return fmt.Errorf("unable to list snapshots: %+v", err)
+	}
+
+	for _, name := range names {
+		c.result.Snapshots++
+
+		data, ok := c.load(ctx, common.Snapshot, name, true)
+		if !ok {
+			continue
+		}
+
+		decrypted, err := crypto.Decrypt(*c.store.master.master, data, nil)
+		if err != nil {
+			c.fail(common.Snapshot, name, fmt.Errorf("unable to decrypt snapshot: %+v", err))
+			continue
+		}
+
+		var snap storage.Snapshot
+		err = json.Unmarshal(decrypted, &snap)
+		if err != nil {
+			c.fail(common.Snapshot, name, fmt.Errorf("unable to parse snapshot: %+v", err))
+			continue
+		}
+
+		for _, meta := range snap.Paths {
+			for _, chunk := range meta.Chunks {
+				if _, ok := c.chunks[chunk]; !ok {
+					c.fail(common.Snapshot, name, fmt.Errorf("%w: %s of %s", ErrChunkNotFound, chunk, meta.Path))
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// readData decrypts the blobs of the selected packs and checks them against
+// their HMAC
+func (c *checker) readData(ctx context.Context) error {
+	packs := make([]string, 0, len(c.headers))
+	for name := range c.headers {
+		packs = append(packs, name)
+	}
+	sort.Strings(packs)
+
+	switch {
+	case c.opts.ReadData:
+	case c.opts.ReadDataSubset > 0:
+		n := int(math.Ceil(float64(len(packs)) * min(c.opts.ReadDataSubset, 1)))
+		rand.Shuffle(len(packs), func(i, j int) { packs[i], packs[j] = packs[j], packs[i] })
+		packs = packs[:n]
+		sort.Strings(packs)
+	default:
+		return nil
+	}
+
+	for _, name := range packs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// blobs are still checked when the pack hash fails, to find out
+		// which are damaged
+		data, ok := c.load(ctx, common.Pack, name, false)
+		if !ok {
+			continue
+		}
+		if crypto.Hash(data).String() != name {
+			c.fail(common.Pack, name, ErrContentHash)
+		}
+		c.result.PacksRead++
+
+		for _, entry := range c.headers[name].Blobs {
+			c.result.BlobsRead++
+
+			if entry.Offset+entry.Length > int64(len(data)) {
+				c.result.Errors = append(c.result.Errors, &CheckError{Type: common.Pack, Name: name, Blob: entry.ID, Err: storage.ErrInvalidPack})
+				continue
+			}
+
+			blob, err := storage.OpenBlob(*c.store.master.master, entry, data[entry.Offset:entry.Offset+entry.Length])
+			switch {
+			case err != nil:
+				err = fmt.Errorf("%w: %+v", ErrChunkIntegrity, err)
+			case c.store.chunkID(blob) != entry.ID:
+				err = ErrChunkIntegrity
+			}
+			if err != nil {
+				c.result.Errors = append(c.result.Errors, &CheckError{Type: common.Pack, Name: name, Blob: entry.ID, Err: err})
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func corruptFile(t *testing.T, p string, offset int) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[offset] ^= 0xff
+
+	err = os.Chmod(p, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(p, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     store.CheckOptions
+		damage   func(t *testing.T)
+		expected error
+	}{
+		{name: "clean", opts: store.CheckOptions{ReadData: true}},
+		{
+			name: "missing pack",
+			damage: func(t *testing.T) {
+				for _, p := range listFiles(t, path.Join(testDir, "packs")) {
+					os.Remove(p)
+				}
+			},
+			expected: store.ErrMissingPack,
+		},
+		{
+			name: "corrupt snapshot",
+			damage: func(t *testing.T) {
+				corruptFile(t, listFiles(t, path.Join(testDir, "snapshots"))[0], 0)
+			},
+			expected: store.ErrContentHash,
+		},
+		{
+			// blob data is only read with --read-data
+			name: "corrupt blob unchecked",
+			damage: func(t *testing.T) {
+				corruptFile(t, listFiles(t, path.Join(testDir, "packs"))[0], 0)
+			},
+		},
+		{
+			name: "corrupt blob",
+			opts: store.CheckOptions{ReadDataSubset: 1},
+			damage: func(t *testing.T) {
+				corruptFile(t, listFiles(t, path.Join(testDir, "packs"))[0], 0)
+			},
+			expected: store.ErrChunkIntegrity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStore(t)
+
+			ctx := context.Background()
+			s := createAndInitStore(ctx, t)
+
+			err := s.Backup(ctx, createBackupDir(t), store.BackupOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.damage != nil {
+				tt.damage(t)
+			}
+
+			result, err := s.Check(ctx, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.expected == nil {
+				if err = result.Err(); err != nil {
+					t.Fatalf("expected no errors, got %v", result.Errors)
+				}
+				if result.Packs != 1 || result.Snapshots != 1 || result.IndexFiles != 1 || result.Keys != 1 {
+					t.Fatalf("expected 1 pack, snapshot, index file and key, got %+v", result)
+				}
+				if tt.opts.ReadData && result.BlobsRead != result.Blobs {
+					t.Fatalf("expected %d blobs read, got %d", result.Blobs, result.BlobsRead)
+				}
+				return
+			}
+
+			if !errors.Is(result.Err(), store.ErrCheckFailed) {
+				t.Fatalf("expected error %+v, got %+v", store.ErrCheckFailed, result.Err())
+			}
+
+			for _, e := range result.Errors {
+				if errors.Is(e, tt.expected) {
+					return
+				}
+			}
+			t.Fatalf("expected error %+v, got %v", tt.expected, result.Errors)
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded    ChangeKind = "added"
+	ChangeRemoved  ChangeKind = "removed"
+	ChangeModified ChangeKind = "modified"
+	// ChangeMetadata marks nodes whose content is unchanged but whose
+	// permissions, owner, times or extended attributes differ
+	ChangeMetadata ChangeKind = "metadata"
+)
+
+// Change is a node that differs between two snapshots. Old is nil for added
+// nodes and New for removed ones.
+type Change struct {
+	Kind ChangeKind
+	Path string
+	Old  *storage.PathMetadata
+	New  *storage.PathMetadata
+}
+
+// DiffResult lists the changes from one snapshot to another, sorted by path
+type DiffResult struct {
+	From    string
+	To      string
+	Changes []Change
+
+	Added           int
+	Removed         int
+	Modified        int
+	MetadataChanged int
+
+	// NewChunks counts the chunks To added to the store, those no snapshot
+	// older than To references, and NewSize their stored size
+	NewChunks int
+	NewSize   int64
+}
+
+// Diff compares two snapshots given by ID, unique ID prefix or latest. Only
+// snapshot metadata and the index are read. New chunks are counted against
+// every snapshot older than To, not just From.
+func (s *Store) Diff(ctx context.Context, fromID string, toID string) (*DiffResult, error) {
+	from, err := s.FindSnapshot(ctx, fromID)
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := s.FindSnapshot(ctx, toID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := DiffSnapshots(*from, *to)
+
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]struct{})
+	for _, snap := range snaps {
+		if snap.ID == to.ID || !snap.CreatedAt.Before(to.CreatedAt) {
+			continue
+		}
+		for _, meta := range snap.Paths {
+			for _, chunk := range meta.Chunks {
+				known[chunk] = struct{}{}
+			}
+		}
+	}
+	for _, meta := range to.Paths {
+		for _, chunk := range meta.Chunks {
+			if _, ok := known[chunk]; ok {
+				continue
+			}
+			known[chunk] = struct{}{}
+
+			result.NewChunks++
+			if loc, ok := s.index.Lookup(chunk); ok {
+				result.NewSize += loc.Length()
+			} else {
+				warden.Log.Debug().Msgf("chunk %s of %s is not indexed", chunk, meta.Path)
+			}
+		}
+	}
+
+	return &result, nil
+}
+
+// DiffSnapshots compares the nodes of two snapshots by path. Chunk counts are
+// left for the caller.
+func DiffSnapshots(from storage.Snapshot, to storage.Snapshot) DiffResult {
+	result := DiffResult{From: from.ID, To: to.ID}
+
+	old := make(map[string]*storage.PathMetadata, len(from.Paths))
+	for i := range from.Paths {
+		old[from.Paths[i].Path] = &from.Paths[i]
+	}
+
+	seen := make(map[string]bool, len(to.Paths))
+	for i := range to.Paths {
+		node := &to.Paths[i]
+		seen[node.Path] = true
+
+		prev, ok := old[node.Path]
+		switch {
+		case !ok:
+			result.Changes = append(result.Changes, Change{Kind: ChangeAdded, Path: node.Path, New: node})
+			result.Added++
+		case !sameContent(*prev, *node):
+			result.Changes = append(result.Changes, Change{Kind: ChangeModified, Path: node.Path, Old: prev, New: node})
+			result.Modified++
+		case !sameMetadata(*prev, *node):
+			result.Changes = append(result.Changes, Change{Kind: ChangeMetadata, Path: node.Path, Old: prev, New: node})
+			result.MetadataChanged++
+		}
+	}
+
+	for i := range from.Paths {
+		node := &from.Paths[i]
+		if !seen[node.Path] {
+			result.Changes = append(result.Changes, Change{Kind: ChangeRemoved, Path: node.Path, Old: node})
+			result.Removed++
+		}
+	}
+
+	sort.SliceStable(result.Changes, func(i, j int) bool {
+		return result.Changes[i].Path < result.Changes[j].Path
+	})
+
+	return result
+}
+
+// sameContent reports whether two nodes have the same type and content. Files
+// compare their chunk lists, so a rewritten file with the same data is
+// unchanged.
+func sameContent(a storage.PathMetadata, b storage.PathMetadata) bool {
+	if a.IsFile() != b.IsFile() || (!a.IsFile() && a.Type != b.Type) {
+		return false
+	}
+
+	return a.FileSize == b.FileSize &&
+		slices.Equal(a.Chunks, b.Chunks) &&
+		a.LinkTarget == b.LinkTarget &&
+		a.DeviceNumber == b.DeviceNumber
+}
+
+// sameMetadata reports whether two nodes have the same permissions, owner,
+// modification time and extended attributes. Access and change times are
+// ignored since reading or backing up a file can update them.
+func sameMetadata(a storage.PathMetadata, b storage.PathMetadata) bool {
+	return a.FilePerm == b.FilePerm &&
+		a.UID == b.UID && a.GID == b.GID &&
+		a.User == b.User && a.Group == b.Group &&
+		a.ModifiedAt.Equal(b.ModifiedAt) &&
+		slices.EqualFunc(a.Xattrs, b.Xattrs, func(x storage.Xattr, y storage.Xattr) bool {
+			return x.Name == y.Name && bytes.Equal(x.Value, y.Value)
+		})
+}
@@ -0,0 +1,141 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	mtime := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	base := storage.PathMetadata{Type: storage.NodeFile, FilePerm: "0644", FileSize: 3, ModifiedAt: mtime, Chunks: []string{"c1"}}
+	with := func(p string, f func(*storage.PathMetadata)) storage.PathMetadata {
+		meta := base
+		meta.Path = p
+		if f != nil {
+			f(&meta)
+		}
+		return meta
+	}
+
+	from := storage.Snapshot{ID: "from", Paths: []storage.PathMetadata{
+		with("same", nil),
+		with("removed", nil),
+		with("content", nil),
+		with("perm", nil),
+		with("touched", nil),
+		with("link", func(m *storage.PathMetadata) { m.Type, m.LinkTarget, m.Chunks = storage.NodeSymlink, "a", nil }),
+		with("type", nil),
+		with("atime", nil),
+	}}
+	to := storage.Snapshot{ID: "to", Paths: []storage.PathMetadata{
+		with("same", nil),
+		with("added", nil),
+		with("content", func(m *storage.PathMetadata) { m.Chunks = []string{"c2"} }),
+		with("perm", func(m *storage.PathMetadata) { m.FilePerm = "0600" }),
+		with("touched", func(m *storage.PathMetadata) { m.ModifiedAt = mtime.Add(time.Second) }),
+		with("link", func(m *storage.PathMetadata) { m.Type, m.LinkTarget, m.Chunks = storage.NodeSymlink, "b", nil }),
+		with("type", func(m *storage.PathMetadata) { m.Type, m.Chunks, m.FileSize = storage.NodeDir, nil, 0 }),
+		with("atime", func(m *storage.PathMetadata) { m.AccessedAt = mtime.Add(time.Hour) }),
+	}}
+
+	expected := map[string]store.ChangeKind{
+		"added":   store.ChangeAdded,
+		"content": store.ChangeModified,
+		"link":    store.ChangeModified,
+		"perm":    store.ChangeMetadata,
+		"removed": store.ChangeRemoved,
+		"touched": store.ChangeMetadata,
+		"type":    store.ChangeModified,
+	}
+
+	result := store.DiffSnapshots(from, to)
+	if len(result.Changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %+v", len(expected), result.Changes)
+	}
+	for i, change := range result.Changes {
+		if i > 0 && result.Changes[i-1].Path > change.Path {
+			t.Fatalf("expected changes sorted by path, got %s before %s", result.Changes[i-1].Path, change.Path)
+		}
+		if kind := expected[change.Path]; kind != change.Kind {
+			t.Fatalf("expected %s to be %s, got %s", change.Path, kind, change.Kind)
+		}
+	}
+
+	if result.Added != 1 || result.Removed != 1 || result.Modified != 3 || result.MetadataChanged != 2 {
+		t.Fatalf("unexpected counts %+v", result)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	dir := createBackupDir(t)
+	err := s.Backup(ctx, dir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := snaps[0].ID
+
+	writeRandomFile(t, path.Join(dir, "new.bin"), 32*1024)
+	if err = os.Remove(path.Join(dir, "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chmod(path.Join(dir, "nested", "b.txt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Backup(ctx, dir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := s.Diff(ctx, first, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.From != first || result.Added != 1 || result.Removed != 1 || result.MetadataChanged != 1 {
+		t.Fatalf("expected one added, removed and changed path, got %+v", result)
+	}
+	if result.NewChunks == 0 || result.NewSize < 32*1024 {
+		t.Fatalf("expected the new file's chunks to be counted, got %d chunks of %d bytes", result.NewChunks, result.NewSize)
+	}
+
+	added := result.NewChunks
+
+	result, err = s.Diff(ctx, "latest", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Changes) != 0 || result.NewChunks != added {
+		t.Fatalf("expected no changes and the %d chunks added by latest, got %+v", added, result)
+	}
+
+	// the new file is already stored by the second snapshot, so a third
+	// snapshot of the same data adds nothing even when compared to the first
+	err = s.Backup(ctx, dir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = s.Diff(ctx, first, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 1 || result.NewChunks != 0 || result.NewSize != 0 {
+		t.Fatalf("expected one added path and no new chunks, got %+v", result)
+	}
+}
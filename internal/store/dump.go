@@ -0,0 +1,222 @@
+package store
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type DumpFormat string
+
+const (
+	DumpTar DumpFormat = "tar"
+	DumpZip DumpFormat = "zip"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported dump format")
+)
+
+// Dump writes the subtree of a snapshot at dir to w as an archive. Entries
+// keep their path relative to the backup volume, and file contents are
+// streamed chunk by chunk without being staged on disk. Tar archives keep
+// permissions, times, ownership, extended attributes, symlinks, hardlinks and
+// device nodes; zip archives keep permissions, mtimes and symlinks. Sockets,
+// and in zip archives also device nodes and FIFOs, are skipped.
+func (s *Store) Dump(ctx context.Context, snapshotID string, dir string, format DumpFormat, w io.Writer) error {
+	var archive archiveWriter
+	switch format {
+	case DumpTar:
+		archive = &tarWriter{tw: tar.NewWriter(w), links: make(map[inodeKey]string)}
+	case DumpZip:
+		archive = &zipWriter{zw: zip.NewWriter(w)}
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+
+	snap, err := s.FindSnapshot(ctx, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := ListNodes(*snap, dir, true)
+	if err != nil {
+		return err
+	}
+
+	// the directory itself comes first so its metadata is kept
+	root := snapshotPath(*snap, dir)
+	for _, node := range snap.Paths {
+		if node.Path == root && node.Type == storage.NodeDir {
+			nodes = append([]storage.PathMetadata{node}, nodes...)
+			break
+		}
+	}
+
+	loader := newBlobLoader(s)
+	for _, node := range nodes {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		warden.Log.Debug().Msgf("dumping %s...", node.Path)
+		err = archive.WriteNode(node, func(w io.Writer) error {
+			return writeChunks(ctx, loader, node, w)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to dump %s: %w", node.Path, err)
+		}
+	}
+
+	return archive.Close()
+}
+
+// writeChunks writes the content of a file to w
+func writeChunks(ctx context.Context, loader *blobLoader, meta storage.PathMetadata, w io.Writer) error {
+	for _, id := range meta.Chunks {
+		data, err := loader.Load(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archiveWriter adds snapshot nodes to an archive. content writes the data of
+// a regular file.
+type archiveWriter interface {
+	WriteNode(meta storage.PathMetadata, content func(io.Writer) error) error
+	Close() error
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	// links maps hardlinked inodes to the first path written for them
+	links map[inodeKey]string
+}
+
+func (t *tarWriter) WriteNode(meta storage.PathMetadata, content func(io.Writer) error) error {
+	mode, err := strconv.ParseInt(meta.FilePerm, 8, 64)
+	if err != nil {
+		return fmt.Errorf("invalid file permission %q: %+v", meta.FilePerm, err)
+	}
+
+	hdr := &tar.Header{
+		Name:       meta.Path,
+		Mode:       mode,
+		Uid:        int(meta.UID),
+		Gid:        int(meta.GID),
+		Uname:      meta.User,
+		Gname:      meta.Group,
+		ModTime:    meta.ModifiedAt,
+		AccessTime: meta.AccessedAt,
+		ChangeTime: meta.ChangedAt,
+		Format:     tar.FormatPAX,
+	}
+	for _, x := range meta.Xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords["SCHILY.xattr."+x.Name] = string(x.Value)
+	}
+
+	key := inodeKey{device: meta.Device, inode: meta.Inode}
+	switch meta.Type {
+	case storage.NodeFile, "":
+		if first, ok := t.links[key]; ok && meta.Links > 1 {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			return t.tw.WriteHeader(hdr)
+		}
+		if meta.Links > 1 {
+			t.links[key] = meta.Path
+		}
+
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = meta.FileSize
+		if err = t.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		return content(t.tw)
+	case storage.NodeDir:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case storage.NodeSymlink:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = meta.LinkTarget
+	case storage.NodeDevice, storage.NodeCharDevice:
+		hdr.Typeflag = tar.TypeBlock
+		if meta.Type == storage.NodeCharDevice {
+			hdr.Typeflag = tar.TypeChar
+		}
+		hdr.Devmajor = int64(meta.DeviceMajor())
+		hdr.Devminor = int64(meta.DeviceMinor())
+	case storage.NodeFIFO:
+		hdr.Typeflag = tar.TypeFifo
+	default:
+		warden.Log.Debug().Msgf("skipping %s node %s", meta.Type, meta.Path)
+		return nil
+	}
+
+	return t.tw.WriteHeader(hdr)
+}
+
+func (t *tarWriter) Close() error {
+	return t.tw.Close()
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) WriteNode(meta storage.PathMetadata, content func(io.Writer) error) error {
+	mode, err := meta.Mode()
+	if err != nil {
+		return err
+	}
+
+	hdr := &zip.FileHeader{Name: meta.Path, Modified: meta.ModifiedAt}
+	hdr.SetMode(mode)
+
+	switch meta.Type {
+	case storage.NodeFile, "":
+		hdr.Method = zip.Deflate
+		w, err := z.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		return content(w)
+	case storage.NodeDir:
+		hdr.Name += "/"
+		_, err = z.zw.CreateHeader(hdr)
+		return err
+	case storage.NodeSymlink:
+		// zip stores a symlink as a file holding its target
+		w, err := z.zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, meta.LinkTarget)
+		return err
+	default:
+		warden.Log.Debug().Msgf("skipping %s node %s", meta.Type, meta.Path)
+		return nil
+	}
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
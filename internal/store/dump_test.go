@@ -0,0 +1,155 @@
+package store_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func TestDump(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	dir := createBackupDir(t)
+	if err := os.Symlink("b.txt", path.Join(dir, "nested", "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path.Join(dir, "nested", "b.txt"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Backup(ctx, dir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"nested/":        "",
+		"nested/b.txt":   "",
+		"nested/c/":      "",
+		"nested/c/d.bin": "",
+		"nested/link":    "b.txt",
+	}
+	for _, name := range []string{"nested/b.txt", "nested/c/d.bin"} {
+		data, err := os.ReadFile(path.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected[name] = string(data)
+	}
+
+	tests := []struct {
+		format store.DumpFormat
+		read   func(t *testing.T, data []byte) map[string]string
+	}{
+		{format: store.DumpTar, read: readTar},
+		{format: store.DumpZip, read: readZip},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			err := s.Dump(ctx, "latest", "nested", tt.format, &buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entries := tt.read(t, buf.Bytes())
+			if len(entries) != len(expected) {
+				t.Fatalf("expected entries %v, got %v", slices.Sorted(maps.Keys(expected)), slices.Sorted(maps.Keys(entries)))
+			}
+			for name, content := range expected {
+				got, ok := entries[name]
+				if !ok {
+					t.Fatalf("expected %s in archive, got %v", name, slices.Sorted(maps.Keys(entries)))
+				}
+				if got != content {
+					t.Fatalf("expected %s to hold %d bytes, got %d", name, len(content), len(got))
+				}
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	err = s.Dump(ctx, "latest", "", store.DumpFormat("rar"), &buf)
+	if !errors.Is(err, store.ErrUnsupportedFormat) {
+		t.Fatalf("expected %v, got %v", store.ErrUnsupportedFormat, err)
+	}
+}
+
+// readTar returns the content of each entry, or the target of symlinks, and
+// checks that b.txt kept its mode
+func readTar(t *testing.T, data []byte) map[string]string {
+	entries := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = string(content)
+
+		switch hdr.Name {
+		case "nested/link":
+			if hdr.Typeflag != tar.TypeSymlink {
+				t.Fatalf("expected a symlink, got type %c", hdr.Typeflag)
+			}
+			entries[hdr.Name] = hdr.Linkname
+		case "nested/b.txt":
+			if hdr.Mode != 0640 || hdr.ModTime.IsZero() {
+				t.Fatalf("expected mode 0640 and an mtime, got %o and %v", hdr.Mode, hdr.ModTime)
+			}
+		}
+	}
+
+	return entries
+}
+
+func readZip(t *testing.T, data []byte) map[string]string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name] = string(content)
+
+		if f.Name == "nested/b.txt" && f.Mode().Perm() != 0640 {
+			t.Fatalf("expected mode 0640, got %o", f.Mode().Perm())
+		}
+		if f.Name == "nested/link" && f.Mode()&os.ModeSymlink == 0 {
+			t.Fatalf("expected a symlink, got mode %v", f.Mode())
+		}
+	}
+
+	return entries
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julianstephens/warden/internal/storage"
+)
+
+var (
+	ErrPathNotFound = errors.New("path not found in snapshot")
+)
+
+// ListNodes returns the nodes of a snapshot below dir, sorted by path. dir is
+// relative to the backup volume or an absolute path inside it, and empty for
+// the whole snapshot. Without recursive only the direct children of dir are
+// returned, and a dir naming a file returns that file. Directories only
+// implied by nested paths, as in snapshots written before directories were
+// recorded, are then listed as directories without metadata.
+func ListNodes(snap storage.Snapshot, dir string, recursive bool) ([]storage.PathMetadata, error) {
+	dir = snapshotPath(snap, dir)
+
+	recorded := make(map[string]bool, len(snap.Paths))
+	for _, node := range snap.Paths {
+		recorded[node.Path] = true
+	}
+
+	var nodes []storage.PathMetadata
+	implied := make(map[string]bool)
+	found := dir == ""
+	for _, node := range snap.Paths {
+		if node.Path == dir {
+			if node.Type != storage.NodeDir {
+				return []storage.PathMetadata{node}, nil
+			}
+			found = true
+			continue
+		}
+
+		rel, ok := relativeTo(dir, node.Path)
+		if !ok {
+			continue
+		}
+		found = true
+
+		if i := strings.Index(rel, "/"); i >= 0 && !recursive {
+			child := path.Join(dir, rel[:i])
+			if !recorded[child] && !implied[child] {
+				nodes = append(nodes, storage.PathMetadata{Path: child, Type: storage.NodeDir})
+				implied[child] = true
+			}
+			continue
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrPathNotFound, dir)
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Path < nodes[j].Path
+	})
+
+	return nodes, nil
+}
+
+// snapshotPath turns p into a slash separated path relative to the backup
+// volume of snap
+func snapshotPath(snap storage.Snapshot, p string) string {
+	p = strings.ReplaceAll(p, "\\", "/")
+	volume := strings.TrimSuffix(strings.ReplaceAll(snap.BackupVolume, "\\", "/"), "/")
+	if volume != "" && (p == volume || strings.HasPrefix(p, volume+"/")) {
+		p = strings.TrimPrefix(p, volume)
+	}
+
+	p = path.Clean("/" + p)
+	return strings.TrimPrefix(p, "/")
+}
+
+// relativeTo returns p relative to dir if p is below dir
+func relativeTo(dir string, p string) (string, bool) {
+	if dir == "" {
+		return p, true
+	}
+
+	rel, ok := strings.CutPrefix(p, dir+"/")
+	return rel, ok
+}
+
+// FindOptions select the nodes Find reports. Zero values match everything.
+type FindOptions struct {
+	// Pattern is a glob matched against node names, or against the whole
+	// path if it contains a slash
+	Pattern    string
+	IgnoreCase bool
+	// Snapshots are IDs or unique ID prefixes to search instead of every
+	// snapshot passing Filter
+	Snapshots []string
+	Filter    SnapshotFilter
+	// NewerThan and OlderThan bound the modification time
+	NewerThan time.Time
+	OlderThan time.Time
+	MinSize   int64
+	// MaxSize is ignored when zero
+	MaxSize int64
+}
+
+// FindMatch is a node matching a search and the snapshot holding it
+type FindMatch struct {
+	Snapshot storage.Snapshot
+	Node     storage.PathMetadata
+}
+
+// match reports whether a node passes the options
+func (o FindOptions) match(node storage.PathMetadata) bool {
+	switch {
+	case !o.NewerThan.IsZero() && node.ModifiedAt.Before(o.NewerThan):
+		return false
+	case !o.OlderThan.IsZero() && node.ModifiedAt.After(o.OlderThan):
+		return false
+	case node.FileSize < o.MinSize:
+		return false
+	case o.MaxSize > 0 && node.FileSize > o.MaxSize:
+		return false
+	case o.Pattern == "":
+		return true
+	}
+
+	pattern, name := o.Pattern, node.Path
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	} else {
+		pattern = strings.Trim(pattern, "/")
+	}
+	if o.IgnoreCase {
+		pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+	}
+
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+// Find searches the metadata of snapshots for matching nodes. Matches are
+// ordered by snapshot, oldest first, then by path. Pack data is never read.
+func (s *Store) Find(ctx context.Context, opts FindOptions) ([]FindMatch, error) {
+	if _, err := path.Match(opts.Pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %q: %w", opts.Pattern, err)
+	}
+
+	snaps, err := s.findSnapshots(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []FindMatch
+	for _, snap := range snaps {
+		var nodes []storage.PathMetadata
+		for _, node := range snap.Paths {
+			if opts.match(node) {
+				nodes = append(nodes, node)
+			}
+		}
+
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].Path < nodes[j].Path
+		})
+		for _, node := range nodes {
+			matches = append(matches, FindMatch{Snapshot: snap, Node: node})
+		}
+	}
+
+	return matches, nil
+}
+
+// findSnapshots returns the snapshots selected by opts, oldest first
+func (s *Store) findSnapshots(ctx context.Context, opts FindOptions) ([]storage.Snapshot, error) {
+	if len(opts.Snapshots) == 0 {
+		return s.FindSnapshots(ctx, opts.Filter)
+	}
+
+	var snaps []storage.Snapshot
+	for _, id := range opts.Snapshots {
+		snap, err := s.FindSnapshot(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.Filter.Match(*snap) && !slices.ContainsFunc(snaps, func(other storage.Snapshot) bool { return other.ID == snap.ID }) {
+			snaps = append(snaps, *snap)
+		}
+	}
+
+	sort.SliceStable(snaps, func(i, j int) bool {
+		return snaps[i].CreatedAt.Before(snaps[j].CreatedAt)
+	})
+
+	return snaps, nil
+}
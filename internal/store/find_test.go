@@ -0,0 +1,127 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func TestListNodes(t *testing.T) {
+	snap := storage.Snapshot{
+		BackupVolume: "/data",
+		Paths: []storage.PathMetadata{
+			{Path: "a.txt", Type: storage.NodeFile},
+			{Path: "nested", Type: storage.NodeDir},
+			{Path: "nested/b.txt", Type: storage.NodeFile},
+			{Path: "nested/c", Type: storage.NodeDir},
+			{Path: "nested/c/d.bin", Type: storage.NodeFile},
+			// written before directories were recorded
+			{Path: "legacy/e.txt"},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		dir       string
+		recursive bool
+		expected  []string
+		err       error
+	}{
+		{name: "root", expected: []string{"a.txt", "legacy", "nested"}},
+		{name: "root recursive", recursive: true, expected: []string{"a.txt", "legacy/e.txt", "nested", "nested/b.txt", "nested/c", "nested/c/d.bin"}},
+		{name: "dir", dir: "nested", expected: []string{"nested/b.txt", "nested/c"}},
+		{name: "dir recursive", dir: "/nested/", recursive: true, expected: []string{"nested/b.txt", "nested/c", "nested/c/d.bin"}},
+		{name: "absolute", dir: "/data/nested/c", expected: []string{"nested/c/d.bin"}},
+		{name: "implied dir", dir: "legacy", expected: []string{"legacy/e.txt"}},
+		{name: "file", dir: "nested/b.txt", expected: []string{"nested/b.txt"}},
+		{name: "missing", dir: "missing", err: store.ErrPathNotFound},
+		{name: "prefix only", dir: "nest", err: store.ErrPathNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := store.ListNodes(snap, tt.dir, tt.recursive)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+
+			var paths []string
+			for _, node := range nodes {
+				paths = append(paths, node.Path)
+			}
+			if !slices.Equal(paths, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	dir := createBackupDir(t)
+	for _, tag := range []string{"first", "second"} {
+		err := s.Backup(ctx, dir, store.BackupOptions{Tags: []string{tag}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snaps, err := s.FindSnapshots(ctx, store.SnapshotFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     store.FindOptions
+		expected []string
+	}{
+		{name: "name", opts: store.FindOptions{Pattern: "*.txt"}, expected: []string{"a.txt", "nested/b.txt", "a.txt", "nested/b.txt"}},
+		{name: "ignore case", opts: store.FindOptions{Pattern: "D.BIN", IgnoreCase: true}, expected: []string{"nested/c/d.bin", "nested/c/d.bin"}},
+		{name: "path", opts: store.FindOptions{Pattern: "nested/*"}, expected: []string{"nested/b.txt", "nested/c", "nested/b.txt", "nested/c"}},
+		{name: "snapshot", opts: store.FindOptions{Pattern: "a.txt", Snapshots: []string{snaps[1].ID[:8]}}, expected: []string{"a.txt"}},
+		{name: "tag", opts: store.FindOptions{Pattern: "a.txt", Filter: store.SnapshotFilter{Tags: []string{"first"}}}, expected: []string{"a.txt"}},
+		{name: "size", opts: store.FindOptions{MinSize: 1024, MaxSize: 64 * 1024}, expected: []string{"nested/c/d.bin", "nested/c/d.bin"}},
+		{name: "newer", opts: store.FindOptions{Pattern: "*.txt", NewerThan: time.Now().Add(time.Hour)}},
+		{name: "older", opts: store.FindOptions{Pattern: "*.txt", OlderThan: time.Now().Add(-time.Hour)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, err := s.Find(ctx, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var paths []string
+			for _, m := range matches {
+				paths = append(paths, m.Node.Path)
+			}
+			if !slices.Equal(paths, tt.expected) {
+				t.Fatalf("expected %v, got %v", tt.expected, paths)
+			}
+		})
+	}
+
+	matches, err := s.Find(ctx, store.FindOptions{Pattern: "a.txt", Snapshots: []string{"latest"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 || matches[0].Snapshot.ID != snaps[1].ID {
+		t.Fatalf("expected a match in the latest snapshot, got %+v", matches)
+	}
+
+	_, err = s.Find(ctx, store.FindOptions{Pattern: "["})
+	if err == nil {
+		t.Fatal("expected an invalid pattern to fail")
+	}
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+var (
+	ErrEmptyPolicy     = errors.New("no retention policy given, refusing to forget every snapshot")
+	ErrInvalidDuration = errors.New("invalid duration")
+)
+
+// Duration is a calendar duration, e.g. 1y6m for a year and a half
+type Duration struct {
+	Years  int
+	Months int
+	Days   int
+	Hours  int
+}
+
+var durationPattern = regexp.MustCompile(`^(?:(\d+)y)?(?:(\d+)m)?(?:(\d+)w)?(?:(\d+)d)?(?:(\d+)h)?$`)
+
+// ParseDuration parses a duration of years (y), months (m), weeks (w), days
+// (d) and hours (h) in that order, e.g. 1y2m, 2w or 36h
+func ParseDuration(s string) (Duration, error) {
+	m := durationPattern.FindStringSubmatch(s)
+	if s == "" || m == nil {
+		return Duration{}, fmt.Errorf("%w: %q", ErrInvalidDuration, s)
+	}
+
+	n := make([]int, len(m)-1)
+	for i, v := range m[1:] {
+		if v != "" {
+			n[i], _ = strconv.Atoi(v)
+		}
+	}
+
+	return Duration{Years: n[0], Months: n[1], Days: n[2]*7 + n[3], Hours: n[4]}, nil
+}
+
+func (d Duration) IsZero() bool {
+	return d == Duration{}
+}
+
+// Before returns the time d before t
+func (d Duration) Before(t time.Time) time.Time {
+	return t.AddDate(-d.Years, -d.Months, -d.Days).Add(-time.Duration(d.Hours) * time.Hour)
+}
+
+func (d Duration) String() string {
+	var b strings.Builder
+	for _, part := range []struct {
+		n    int
+		unit string
+	}{{d.Years, "y"}, {d.Months, "m"}, {d.Days, "d"}, {d.Hours, "h"}} {
+		if part.n != 0 {
+			fmt.Fprintf(&b, "%d%s", part.n, part.unit)
+		}
+	}
+
+	return b.String()
+}
+
+// RetentionPolicy selects the snapshots to keep. A snapshot is kept if any
+// rule selects it.
+type RetentionPolicy struct {
+	// Last keeps the most recent snapshots
+	Last int
+	// Hourly, Daily, Weekly, Monthly and Yearly keep the most recent
+	// snapshot of that many hours, days, weeks, months and years
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+	// Within keeps snapshots taken within a duration of the newest one
+	Within Duration
+	// Tags keeps snapshots with any of the tags
+	Tags []string
+}
+
+func (p RetentionPolicy) Empty() bool {
+	return p.Last == 0 && p.Hourly == 0 && p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0 && p.Yearly == 0 &&
+		p.Within.IsZero() && len(p.Tags) == 0
+}
+
+type ForgetOptions struct {
+	Policy  RetentionPolicy
+	GroupBy GroupBy
+	// DryRun reports the decisions without removing snapshots
+	DryRun bool
+}
+
+// SnapshotDecision records whether a snapshot is kept and which rules kept it
+type SnapshotDecision struct {
+	Snapshot storage.Snapshot
+	Keep     bool
+	Reasons  []string
+}
+
+// ForgetGroup holds the decisions for the snapshots of one host and path,
+// newest first. Host or Path is empty when not grouped by it.
+type ForgetGroup struct {
+	Host      string
+	Path      string
+	Decisions []SnapshotDecision
+}
+
+// Forget applies a retention policy to the snapshots of the store and
+// removes the snapshots no rule keeps under an exclusive lock. Their data
+// stays in the store until it is pruned.
+func (s *Store) Forget(ctx context.Context, opts ForgetOptions) (groups []ForgetGroup, err error) {
+	if opts.Policy.Empty() {
+		return nil, ErrEmptyPolicy
+	}
+
+	err = s.withLock(ctx, !opts.DryRun, func(ctx context.Context) error {
+		groups, err = s.forget(ctx, opts)
+		return err
+	})
+
+	return
+}
+
+func (s *Store) forget(ctx context.Context, opts ForgetOptions) ([]ForgetGroup, error) {
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := groupSnapshots(snaps, opts.GroupBy)
+	for i := range groups {
+		groups[i].Decisions = ApplyPolicy(groups[i].Decisions, opts.Policy)
+	}
+
+	if opts.DryRun {
+		return groups, nil
+	}
+
+	for _, g := range groups {
+		for _, d := range g.Decisions {
+			if d.Keep {
+				continue
+			}
+
+			warden.Log.Debug().Msgf("removing snapshot %s", d.Snapshot.ID)
+			err = s.backend.Remove(ctx, common.Event{Type: common.Snapshot, Name: &d.Snapshot.ID})
+			if err != nil {
+				return groups, fmt.Errorf("unable to remove snapshot %s: %+v", d.Snapshot.ID, err)
+			}
+		}
+	}
+
+	return groups, nil
+}
+
+func groupSnapshots(snaps []storage.Snapshot, by GroupBy) []ForgetGroup {
+	var result []ForgetGroup
+	for _, g := range GroupSnapshots(snaps, by) {
+		group := ForgetGroup{Host: g.Host, Path: g.Path}
+		for _, snap := range g.Snapshots {
+			group.Decisions = append(group.Decisions, SnapshotDecision{Snapshot: snap})
+		}
+		result = append(result, group)
+	}
+
+	return result
+}
+
+// bucketRule keeps the newest snapshot of each of the most recent count
+// buckets, e.g. days
+type bucketRule struct {
+	reason string
+	count  int
+	bucket func(time.Time) string
+}
+
+// ApplyPolicy decides which snapshots the policy keeps. The decisions are
+// returned newest first.
+func ApplyPolicy(decisions []SnapshotDecision, p RetentionPolicy) []SnapshotDecision {
+	decisions = slices.Clone(decisions)
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return decisions[i].Snapshot.CreatedAt.After(decisions[j].Snapshot.CreatedAt)
+	})
+
+	rules := []bucketRule{
+		{"last", p.Last, func(t time.Time) string { return "" }},
+		{"hourly", p.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format(time.DateOnly) }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	last := make([]*string, len(rules))
+
+	var cutoff time.Time
+	if len(decisions) > 0 && !p.Within.IsZero() {
+		cutoff = p.Within.Before(decisions[0].Snapshot.CreatedAt)
+	}
+
+	for i := range decisions {
+		d := &decisions[i]
+		d.Keep = false
+		d.Reasons = nil
+		created := d.Snapshot.CreatedAt.Local()
+
+		for r, rule := range rules {
+			if rule.count <= 0 {
+				continue
+			}
+
+			// every snapshot is its own bucket for keep-last
+			bucket := rule.bucket(created)
+			if rule.reason == "last" || last[r] == nil || *last[r] != bucket {
+				d.Reasons = append(d.Reasons, rule.reason)
+				rules[r].count--
+				last[r] = &bucket
+			}
+		}
+
+		if !cutoff.IsZero() && !d.Snapshot.CreatedAt.Before(cutoff) {
+			d.Reasons = append(d.Reasons, "within "+p.Within.String())
+		}
+
+		for _, tag := range p.Tags {
+			if slices.Contains(d.Snapshot.Tags, tag) {
+				d.Reasons = append(d.Reasons, "tag "+tag)
+			}
+		}
+
+		d.Keep = len(d.Reasons) > 0
+	}
+
+	return decisions
+}
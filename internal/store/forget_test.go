@@ -0,0 +1,183 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected store.Duration
+		err      bool
+	}{
+		{input: "1y2m3d4h", expected: store.Duration{Years: 1, Months: 2, Days: 3, Hours: 4}},
+		{input: "2w", expected: store.Duration{Days: 14}},
+		{input: "1w2d", expected: store.Duration{Days: 9}},
+		{input: "36h", expected: store.Duration{Hours: 36}},
+		{input: "", err: true},
+		{input: "3x", err: true},
+		{input: "4h1d", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			d, err := store.ParseDuration(tt.input)
+			if tt.err {
+				if !errors.Is(err, store.ErrInvalidDuration) {
+					t.Fatalf("expected %v, got %v", store.ErrInvalidDuration, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d != tt.expected {
+				t.Fatalf("expected %+v, got %+v", tt.expected, d)
+			}
+		})
+	}
+}
+
+func TestApplyPolicy(t *testing.T) {
+	start := time.Date(2024, time.February, 10, 12, 0, 0, 0, time.Local)
+
+	// two snapshots a day, at 00:00 and 12:00, for the 60 days up to start
+	var decisions []store.SnapshotDecision
+	for i := range 120 {
+		snap := storage.Snapshot{
+			ID:        string(rune('a' + i%26)),
+			CreatedAt: start.Add(-time.Duration(i) * 12 * time.Hour),
+		}
+		if i == 100 {
+			snap.Tags = []string{"release"}
+		}
+		decisions = append(decisions, store.SnapshotDecision{Snapshot: snap})
+	}
+
+	tests := []struct {
+		name     string
+		policy   store.RetentionPolicy
+		expected []int
+	}{
+		{name: "last", policy: store.RetentionPolicy{Last: 3}, expected: []int{0, 1, 2}},
+		{name: "hourly", policy: store.RetentionPolicy{Hourly: 2}, expected: []int{0, 1}},
+		{name: "daily", policy: store.RetentionPolicy{Daily: 3}, expected: []int{0, 2, 4}},
+		{name: "weekly", policy: store.RetentionPolicy{Weekly: 2}, expected: []int{0, 12}},
+		{name: "monthly", policy: store.RetentionPolicy{Monthly: 3}, expected: []int{0, 20, 82}},
+		{name: "yearly", policy: store.RetentionPolicy{Yearly: 5}, expected: []int{0, 82}},
+		{name: "within", policy: store.RetentionPolicy{Within: store.Duration{Days: 1}}, expected: []int{0, 1, 2}},
+		{name: "tag", policy: store.RetentionPolicy{Tags: []string{"release"}}, expected: []int{100}},
+		{
+			name:     "combined",
+			policy:   store.RetentionPolicy{Last: 1, Daily: 2, Tags: []string{"release"}},
+			expected: []int{0, 2, 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// reverse the input to check the decisions are sorted
+			input := slices.Clone(decisions)
+			slices.Reverse(input)
+
+			result := store.ApplyPolicy(input, tt.policy)
+			if len(result) != len(decisions) {
+				t.Fatalf("expected %d decisions, got %d", len(decisions), len(result))
+			}
+
+			var kept []int
+			for i, d := range result {
+				if !d.Snapshot.CreatedAt.Equal(decisions[i].Snapshot.CreatedAt) {
+					t.Fatalf("expected decisions newest first, got %s at %d", d.Snapshot.CreatedAt, i)
+				}
+				if d.Keep != (len(d.Reasons) > 0) {
+					t.Fatalf("expected reasons for kept snapshots only, got %+v", d)
+				}
+				if d.Keep {
+					kept = append(kept, i)
+				}
+			}
+
+			if !slices.Equal(kept, tt.expected) {
+				t.Fatalf("expected %v kept, got %v", tt.expected, kept)
+			}
+		})
+	}
+}
+
+func TestForget(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := createBackupDir(t)
+	for _, tag := range []string{"keep", "", ""} {
+		opts := store.BackupOptions{}
+		if tag != "" {
+			opts.Tags = []string{tag}
+		}
+
+		err := s.Backup(ctx, backupDir, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := s.Forget(ctx, store.ForgetOptions{})
+	if !errors.Is(err, store.ErrEmptyPolicy) {
+		t.Fatalf("expected %v, got %v", store.ErrEmptyPolicy, err)
+	}
+
+	opts := store.ForgetOptions{
+		Policy:  store.RetentionPolicy{Last: 1, Tags: []string{"keep"}},
+		GroupBy: store.GroupBy{Host: true, Path: true},
+		DryRun:  true,
+	}
+	groups, err := s.Forget(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0].Decisions) != 3 || groups[0].Path != backupDir {
+		t.Fatalf("expected one group of 3 snapshots for %s, got %+v", backupDir, groups)
+	}
+
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 3 {
+		t.Fatalf("expected dry run to keep 3 snapshots, got %d", len(snaps))
+	}
+
+	opts.DryRun = false
+	_, err = s.Forget(ctx, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err = s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(snaps))
+	}
+	for _, snap := range snaps {
+		if snap.ID == groups[0].Decisions[1].Snapshot.ID {
+			t.Fatalf("expected snapshot %s to be forgotten", snap.ID)
+		}
+	}
+
+	err = s.Restore(ctx, "latest", t.TempDir(), store.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+// chunkID computes the store-wide identifier for a chunk. The HMAC is keyed
+// with the master key so every key holder derives the same IDs.
+func (s *Store) chunkID(data []byte) string {
+	return crypto.SecureHash(data, s.master.master.Data)
+}
+
+// loadIndex merges any index files that have not been loaded yet
+func (s *Store) loadIndex(ctx context.Context) error {
+	names, err := s.backend.List(ctx, common.Index)
+	if err != nil {
+		return fmt.Errorf("unable to list index files: %+v", err)
+	}
+
+	loaded := 0
+	for _, name := range names {
+		if s.index.Loaded(name) {
+			continue
+		}
+
+		file, err := s.loadIndexFile(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		s.index.Merge(name, file)
+		loaded++
+	}
+	warden.Log.Debug().Msgf("loaded %d new index files, %d chunks indexed", loaded, s.index.Len())
+
+	return nil
+}
+
+// loadIndexFile reads and decrypts a single index file
+func (s *Store) loadIndexFile(ctx context.Context, name string) (file storage.IndexFile, err error) {
+	data, err := s.backend.Load(ctx, common.Event{Type: common.Index, Name: &name}, 0, 0)
+	if err != nil {
+		err = fmt.Errorf("unable to load index file %s: %+v", name, err)
+		return
+	}
+
+	decrypted, err := crypto.Decrypt(*s.master.master, data, nil)
+	if err != nil {
+		err = fmt.Errorf("unable to decrypt index file %s: %+v", name, err)
+		return
+	}
+
+	err = json.Unmarshal(decrypted, &file)
+	if err != nil {
+		err = fmt.Errorf("unable to parse index file %s: %+v", name, err)
+	}
+
+	return
+}
+
+// saveIndex writes chunks added since the last save to a new index file
+func (s *Store) saveIndex(ctx context.Context) error {
+	file := s.index.Unsaved()
+	if len(file.Chunks) == 0 {
+		return nil
+	}
+
+	fileJson, err := json.Marshal(file)
+	if err != nil {
+		return fmt.Errorf("unable to marshal index: %+v", err)
+	}
+
+	encrypted, err := crypto.Encrypt(*s.master.master, fileJson, nil)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt index: %+v", err)
+	}
+
+	id := crypto.Hash(encrypted)
+	name := id.String()
+	warden.Log.Debug().Msgf("saving index file %s with %d chunks...", name, len(file.Chunks))
+	err = s.backend.Save(ctx, common.Event{Type: common.Index, Name: &name}, common.NewHashedByteReader(encrypted, id[:]))
+	if err != nil {
+		return fmt.Errorf("unable to save index file %s: %+v", name, err)
+	}
+	s.index.MarkSaved(name, file)
+	warden.Log.Debug().Msg("index saved.")
+
+	return nil
+}
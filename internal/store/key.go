@@ -7,7 +7,7 @@ import (
 	"fmt"
 	"os"
 	"os/user"
-	"path"
+	"sort"
 	"strings"
 	"time"
 
@@ -16,12 +16,28 @@ import (
 	"github.com/julianstephens/warden/internal/warden"
 )
 
+const (
+	// keyVersion is the keyfile format written by wrapKey. Version 1 stores
+	// the raw master key, encrypted with the version as additional data.
+	keyVersion    = 1
+	masterKeySize = 32
+)
+
+var (
+	ErrWrongPassword         = errors.New("password does not open key")
+	ErrUnsupportedKeyVersion = errors.New("unsupported keyfile version")
+	ErrKeyNotFound           = errors.New("key not found")
+	ErrKeyInUse              = errors.New("cannot remove the key used to open the store")
+	ErrLastKey               = errors.New("cannot remove the only key of a store")
+)
+
 type Key struct {
 	id warden.ID
 
 	master *crypto.Key
 	user   *crypto.Key
 
+	Version   int           `json:"version,omitempty"`
 	Username  string        `json:"username"`
 	Hostname  string        `json:"hostname"`
 	CreatedAt time.Time     `json:"createdAt"`
@@ -53,22 +69,10 @@ func (k *Key) Decrypt() *crypto.Key {
 	return k.master
 }
 
-// LoadKey decrypts the store master key with a password
-func LoadKey(ctx context.Context, store *Store, storeLoc string, params crypto.Params, password string) (*Key, error) {
-	key, err := findKey(path.Join(storeLoc, "keys"), password)
-	if err != nil {
-		return nil, err
-	}
-
-	keyJson, err := json.Marshal(key)
-	if err != nil {
-		return nil, fmt.Errorf("unable to marshal key to json: %+v", err)
-	}
-
-	id := crypto.Hash(keyJson)
-	key.id = id
-
-	return key, nil
+// LoadKey unwraps the store master key with the first key that opens with
+// the password
+func LoadKey(ctx context.Context, store *Store, password string) (*Key, error) {
+	return findKey(ctx, store, password)
 }
 
 // AddKey creates a new master key and saves it
@@ -76,30 +80,19 @@ func AddKey(ctx context.Context, store *Store, params crypto.Params, password st
 	salt := crypto.NewSalt()
 	warden.Log.Debug().Msg("generated store salt.")
 
-	warden.Log.Debug().Msg("deriving master key from password, params, and salt...")
-	k, err := deriveKey(params, password, salt)
+	master, err := crypto.NewSessionKey(salt)
 	if err != nil {
 		return nil, err
 	}
 	warden.Log.Debug().Msg("master key created.")
 
-	warden.Log.Debug().Msg("generating keyfile...")
-	keyJson, err := json.Marshal(k)
+	warden.Log.Debug().Msg("wrapping master key with password, params, and salt...")
+	k, err := wrapKey(params, password, salt, master)
 	if err != nil {
-		return nil, fmt.Errorf("unable to marshal key to json: %+v", err)
-	}
-	warden.Log.Debug().Msg("keyfile created.")
-
-	id := crypto.Hash(keyJson)
-	k.id = id
-	name := id.String()
-
-	event := common.Event{
-		Type: common.Key,
-		Name: &name,
+		return nil, err
 	}
 
-	err = store.backend.Save(ctx, event, common.NewByteReader(keyJson))
+	err = saveKey(ctx, store, k)
 	if err != nil {
 		return nil, err
 	}
@@ -107,66 +100,38 @@ func AddKey(ctx context.Context, store *Store, params crypto.Params, password st
 	return k, nil
 }
 
-func findKey(keyDir string, password string) (*Key, error) {
-	keys, err := os.ReadDir(keyDir)
+func findKey(ctx context.Context, store *Store, password string) (*Key, error) {
+	keys, err := store.Keys(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	var derivedKey *Key
-	found := false
-
-	for _, k := range keys {
-		if k.IsDir() {
-			return nil, errors.New("malformed store: invalid keys dir")
-		}
-
-		if !strings.HasSuffix(k.Name(), "json") {
-			return nil, errors.New("malformed key: invalid key extension")
-		}
-
-		loadedKey, err := warden.LoadJSON[Key](path.Join(keyDir, k.Name()))
-		if err != nil {
-			return nil, fmt.Errorf("unable to load key (%s): %+v", k.Name(), err)
-		}
-
-		derivedKey, err = deriveKey(loadedKey.Params, password, loadedKey.Salt)
-		if err != nil {
+	for i := range keys {
+		k := &keys[i]
+		err = k.unwrap(password)
+		if errors.Is(err, ErrWrongPassword) {
+			warden.Log.Debug().Msgf("password does not open key %s", k.id)
 			continue
 		}
-
-		_, err = crypto.Decrypt(*derivedKey.user, loadedKey.Data, nil)
 		if err != nil {
-			continue
-		} else {
-			found = true
+			return nil, fmt.Errorf("unable to unwrap key %s: %w", k.id, err)
 		}
-	}
 
-	if !found {
-		return nil, errors.New("unable to retrieve store key")
+		return k, nil
 	}
 
-	return derivedKey, nil
+	return nil, &warden.InvalidPasswordError{Msg: "no key matches the password"}
 }
 
-func deriveKey(params crypto.Params, password string, salt []byte) (key *Key, err error) {
+// wrapKey encrypts the master key with a key derived from a password
+func wrapKey(params crypto.Params, password string, salt []byte, master *crypto.Key) (key *Key, err error) {
 	derivedUser, err := crypto.NewIDKey(params, password, salt)
 	if err != nil {
 		return
 	}
 
-	master, err := crypto.NewSessionKey(salt)
-	if err != nil {
-		return
-	}
-
-	masterJson, err := json.Marshal(master)
-	if err != nil {
-		return
-	}
-
-	encMaster, err := crypto.Encrypt(*derivedUser, masterJson, nil)
+	ad := keyAD(keyVersion)
+	encMaster, err := crypto.Encrypt(*derivedUser, master.Data, &ad)
 	if err != nil {
 		return
 	}
@@ -186,6 +151,7 @@ func deriveKey(params crypto.Params, password string, salt []byte) (key *Key, er
 	key = &Key{
 		master:    master,
 		user:      derivedUser,
+		Version:   keyVersion,
 		Username:  username.Username,
 		Hostname:  hostname,
 		CreatedAt: time.Now(),
@@ -196,3 +162,182 @@ func deriveKey(params crypto.Params, password string, salt []byte) (key *Key, er
 
 	return
 }
+
+// unwrap decrypts the master key of a loaded keyfile with a password
+func (k *Key) unwrap(password string) error {
+	derivedUser, err := crypto.NewIDKey(k.Params, password, k.Salt)
+	if err != nil {
+		return fmt.Errorf("%w: %+v", ErrWrongPassword, err)
+	}
+
+	master := &crypto.Key{}
+	switch k.Version {
+	case 0:
+		// unversioned keyfiles hold the master key as JSON, without
+		// additional data
+		masterJson, err := crypto.Decrypt(*derivedUser, k.Data, nil)
+		if err != nil {
+			return ErrWrongPassword
+		}
+
+		err = json.Unmarshal(masterJson, master)
+		if err != nil {
+			return fmt.Errorf("malformed key: %+v", err)
+		}
+	case keyVersion:
+		ad := keyAD(k.Version)
+		master.Data, err = crypto.Decrypt(*derivedUser, k.Data, &ad)
+		if err != nil {
+			return ErrWrongPassword
+		}
+	default:
+		return fmt.Errorf("%w: %d", ErrUnsupportedKeyVersion, k.Version)
+	}
+
+	if len(master.Data) != masterKeySize {
+		return fmt.Errorf("malformed key: expected %d byte master key, got %d", masterKeySize, len(master.Data))
+	}
+
+	k.master = master
+	k.user = derivedUser
+
+	return nil
+}
+
+// keyAD binds a wrapped master key to its keyfile version
+func keyAD(version int) []byte {
+	return []byte(fmt.Sprintf("warden key v%d", version))
+}
+
+// saveKey writes a keyfile, named by the hash of its contents
+func saveKey(ctx context.Context, store *Store, k *Key) error {
+	warden.Log.Debug().Msg("generating keyfile...")
+	keyJson, err := json.Marshal(k)
+	if err != nil {
+		return fmt.Errorf("unable to marshal key to json: %+v", err)
+	}
+	warden.Log.Debug().Msg("keyfile created.")
+
+	k.id = crypto.Hash(keyJson)
+	name := k.id.String()
+
+	return store.backend.Save(ctx, common.Event{Type: common.Key, Name: &name}, common.NewByteReader(keyJson))
+}
+
+// Keys lists the keyfiles of the store. Their master keys stay wrapped.
+func (s *Store) Keys(ctx context.Context) ([]Key, error) {
+	names, err := s.backend.List(ctx, common.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list keys: %+v", err)
+	}
+
+	keys := make([]Key, 0, len(names))
+	for _, name := range names {
+		data, err := s.backend.Load(ctx, common.Event{Type: common.Key, Name: &name}, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load key %s: %+v", name, err)
+		}
+
+		var k Key
+		err = json.Unmarshal(data, &k)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse key %s: %+v", name, err)
+		}
+
+		k.id, err = warden.ParseID(name)
+		if err != nil {
+			return nil, fmt.Errorf("malformed key: invalid key name %s", name)
+		}
+
+		keys = append(keys, k)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	return keys, nil
+}
+
+// AddPassword saves a new keyfile wrapping the store's master key with another
+// password
+func (s *Store) AddPassword(ctx context.Context, password string) (*Key, error) {
+	params, err := warden.MapToStruct[crypto.Params](s.conf.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	k, err := wrapKey(params, password, crypto.NewSalt(), s.master.master)
+	if err != nil {
+		return nil, err
+	}
+
+	err = saveKey(ctx, s, k)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save key: %+v", err)
+	}
+
+	return k, nil
+}
+
+// RemoveKey deletes the keyfile with the given ID or unique ID prefix under an
+// exclusive lock. The key used to open the store and the last remaining key
+// cannot be removed.
+func (s *Store) RemoveKey(ctx context.Context, id string) error {
+	return s.withLock(ctx, true, func(ctx context.Context) error {
+		return s.removeKey(ctx, id)
+	})
+}
+
+func (s *Store) removeKey(ctx context.Context, id string) error {
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		return err
+	}
+
+	matches := warden.Filter(keys, func(k Key) bool {
+		return strings.HasPrefix(k.id.String(), id)
+	})
+
+	switch {
+	case id == "" || len(matches) == 0:
+		return fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	case len(matches) > 1:
+		return fmt.Errorf("key id %q is ambiguous, matches %d keys", id, len(matches))
+	case len(keys) == 1:
+		return ErrLastKey
+	case matches[0].id == s.master.id:
+		return ErrKeyInUse
+	}
+
+	name := matches[0].id.String()
+	return s.backend.Remove(ctx, common.Event{Type: common.Key, Name: &name})
+}
+
+// ChangePassword replaces the key used to open the store with one for a new
+// password under an exclusive lock. Only the master key is re-encrypted,
+// stored data is untouched.
+func (s *Store) ChangePassword(ctx context.Context, password string) (k *Key, err error) {
+	err = s.withLock(ctx, true, func(ctx context.Context) error {
+		k, err = s.changePassword(ctx, password)
+		return err
+	})
+
+	return
+}
+
+func (s *Store) changePassword(ctx context.Context, password string) (*Key, error) {
+	k, err := s.AddPassword(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+
+	old := s.master.id.String()
+	err = s.backend.Remove(ctx, common.Event{Type: common.Key, Name: &old})
+	if err != nil {
+		return nil, fmt.Errorf("unable to remove old key %s: %+v", old, err)
+	}
+	s.master = k
+
+	return k, nil
+}
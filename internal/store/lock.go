@@ -0,0 +1,474 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"os/user"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+const (
+	// LockRefreshInterval is how often a held lock is rewritten with a new
+	// creation time
+	LockRefreshInterval = 5 * time.Minute
+	// LockStaleTimeout is the age after which a lock that was not refreshed
+	// is stale
+	LockStaleTimeout = 30 * time.Minute
+)
+
+var (
+	ErrStoreLocked    = errors.New("store is locked")
+	ErrSharedLockHeld = errors.New("store holds a shared lock, an exclusive lock is required")
+	ErrLockLost       = errors.New("lock could not be refreshed and may be taken by another process")
+)
+
+// Lock marks a store as in use. Any number of shared locks may be held at
+// once, while an exclusive lock excludes every other lock.
+type Lock struct {
+	// ID is the hash of the encrypted lock and is not stored in it
+	ID        string    `json:"-"`
+	Exclusive bool      `json:"exclusive"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (l Lock) String() string {
+	mode := "shared"
+	if l.Exclusive {
+		mode = "exclusive"
+	}
+
+	return fmt.Sprintf("%s lock %s by %s@%s (pid %d), created %s",
+		mode, l.ID[:min(8, len(l.ID))], l.Username, l.Hostname, l.PID, l.CreatedAt.Local().Format(time.DateTime))
+}
+
+// Stale reports whether a lock was not refreshed within LockStaleTimeout, or
+// was taken on this host by a process that no longer runs
+func (l Lock) Stale() bool {
+	if time.Since(l.CreatedAt) > LockStaleTimeout {
+		return true
+	}
+
+	hostname, err := os.Hostname()
+	return err == nil && l.Hostname == hostname && !processExists(l.PID)
+}
+
+// LockRetry is the backoff used while a store is locked. Delays double from
+// InitialDelay up to MaxDelay until Timeout has passed.
+type LockRetry struct {
+	// Timeout is how long to keep retrying, 0 tries once
+	Timeout      time.Duration
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+var DefaultLockRetry = LockRetry{
+	Timeout:      time.Minute,
+	InitialDelay: time.Second,
+	MaxDelay:     15 * time.Second,
+}
+
+// LockRefresh is how often a held lock is refreshed, and how long refreshing
+// may fail before the lock is given up. Timeout must stay below
+// LockStaleTimeout, or other processes may take the lock while it is in use.
+type LockRefresh struct {
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+var DefaultLockRefresh = LockRefresh{
+	Interval: LockRefreshInterval,
+	Timeout:  LockStaleTimeout - LockRefreshInterval,
+}
+
+// lockState is the lock held by a store and the goroutine refreshing it.
+// lost is closed once the lock is given up.
+type lockState struct {
+	mu      sync.Mutex
+	lock    *Lock
+	cancel  context.CancelFunc
+	stopped chan struct{}
+	lost    chan struct{}
+}
+
+// Lock takes a shared or exclusive lock on the store, retrying with backoff
+// as configured by s.LockRetry while a conflicting lock is held. The lock is
+// refreshed in the background until Unlock or Close is called.
+//
+// The index is reloaded once the lock is held, as another process may have
+// pruned the store since it was opened.
+func (s *Store) Lock(ctx context.Context, exclusive bool) error {
+	s.locking.mu.Lock()
+	held := s.locking.lock
+	s.locking.mu.Unlock()
+	if held != nil {
+		return fmt.Errorf("%w: %s is already held", ErrStoreLocked, held)
+	}
+
+	retry := s.LockRetry
+	deadline := time.Now().Add(retry.Timeout)
+	delay := max(retry.InitialDelay, 100*time.Millisecond)
+
+	for {
+		lock, err := s.tryLock(ctx, exclusive)
+		if err == nil {
+			s.startRefresh(lock)
+			break
+		}
+
+		if !errors.Is(err, ErrStoreLocked) || time.Now().Add(delay).After(deadline) {
+			return err
+		}
+
+		// jitter keeps processes that conflict with each other from retrying
+		// in lockstep
+		wait := delay + rand.N(delay/4+1)
+		warden.Log.Info().Msgf("%+v, retrying in %s", err, wait.Round(time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+
+		delay = min(delay*2, max(retry.MaxDelay, retry.InitialDelay))
+	}
+
+	s.index = storage.NewIndex()
+	err := s.loadIndex(ctx)
+	if err != nil {
+		s.Unlock(ctx)
+		return err
+	}
+
+	return nil
+}
+
+// tryLock saves a lock, then backs off if any other live lock conflicts with
+// it. Two processes locking at once may both back off, but never both win.
+func (s *Store) tryLock(ctx context.Context, exclusive bool) (*Lock, error) {
+	lock, err := newLock(exclusive)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.saveLock(ctx, lock)
+	if err != nil {
+		return nil, err
+	}
+
+	locks, err := s.Locks(ctx)
+	if err != nil {
+		s.removeLock(ctx, lock.ID)
+		return nil, err
+	}
+
+	for _, other := range locks {
+		if other.ID == lock.ID || !exclusive && !other.Exclusive {
+			continue
+		}
+
+		if other.Stale() {
+			warden.Log.Debug().Msgf("ignoring stale %s", other)
+			continue
+		}
+
+		s.removeLock(ctx, lock.ID)
+		return nil, fmt.Errorf("%w: %s", ErrStoreLocked, other)
+	}
+
+	return lock, nil
+}
+
+func newLock(exclusive bool) (*Lock, error) {
+	username, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get current user: %+v", err)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get hostname: %+v", err)
+	}
+
+	return &Lock{
+		Exclusive: exclusive,
+		Hostname:  hostname,
+		Username:  username.Username,
+		PID:       os.Getpid(),
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// saveLock encrypts a lock and saves it under the hash of its contents
+func (s *Store) saveLock(ctx context.Context, lock *Lock) error {
+	lockJson, err := json.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("unable to marshal lock: %+v", err)
+	}
+
+	encrypted, err := crypto.Encrypt(*s.master.master, lockJson, nil)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt lock: %+v", err)
+	}
+
+	id := crypto.Hash(encrypted)
+	name := id.String()
+	warden.Log.Debug().Msgf("saving lock %s...", name)
+	err = s.backend.Save(ctx, common.Event{Type: common.Lock, Name: &name}, common.NewHashedByteReader(encrypted, id[:]))
+	if err != nil {
+		return fmt.Errorf("unable to save lock %s: %+v", name, err)
+	}
+	lock.ID = name
+
+	return nil
+}
+
+func (s *Store) removeLock(ctx context.Context, name string) error {
+	warden.Log.Debug().Msgf("removing lock %s", name)
+	err := s.backend.Remove(ctx, common.Event{Type: common.Lock, Name: &name})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unable to remove lock %s: %+v", name, err)
+	}
+
+	return nil
+}
+
+// Locks loads every lock in the store, oldest first. Locks removed while
+// they are listed are skipped.
+func (s *Store) Locks(ctx context.Context) ([]Lock, error) {
+	names, err := s.backend.List(ctx, common.Lock)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list locks: %+v", err)
+	}
+
+	locks := make([]Lock, 0, len(names))
+	for _, name := range names {
+		data, err := s.backend.Load(ctx, common.Event{Type: common.Lock, Name: &name}, 0, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to load lock %s: %+v", name, err)
+		}
+
+		decrypted, err := crypto.Decrypt(*s.master.master, data, nil)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decrypt lock %s: %+v", name, err)
+		}
+
+		var lock Lock
+		err = json.Unmarshal(decrypted, &lock)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse lock %s: %+v", name, err)
+		}
+		lock.ID = name
+		locks = append(locks, lock)
+	}
+
+	sort.Slice(locks, func(i, j int) bool {
+		return locks[i].CreatedAt.Before(locks[j].CreatedAt)
+	})
+
+	return locks, nil
+}
+
+// RefreshLock replaces the held lock with one created now, so it does not
+// become stale. The new lock is saved before the old one is removed.
+func (s *Store) RefreshLock(ctx context.Context) error {
+	s.locking.mu.Lock()
+	defer s.locking.mu.Unlock()
+
+	if s.locking.lock == nil {
+		return nil
+	}
+
+	lock, err := newLock(s.locking.lock.Exclusive)
+	if err != nil {
+		return err
+	}
+
+	err = s.saveLock(ctx, lock)
+	if err != nil {
+		return err
+	}
+
+	old := s.locking.lock.ID
+	s.locking.lock = lock
+
+	return s.removeLock(context.WithoutCancel(ctx), old)
+}
+
+// startRefresh refreshes lock in the background. Once refreshing has failed
+// for longer than s.LockRefresh.Timeout the lock is given up and operations
+// running under withLock are cancelled.
+func (s *Store) startRefresh(lock *Lock) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	lost := make(chan struct{})
+
+	s.locking.mu.Lock()
+	s.locking.lock = lock
+	s.locking.cancel = cancel
+	s.locking.stopped = stopped
+	s.locking.lost = lost
+	s.locking.mu.Unlock()
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(s.LockRefresh.Interval)
+		defer ticker.Stop()
+
+		refreshed := lock.CreatedAt
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := s.RefreshLock(ctx)
+				if err == nil {
+					refreshed = time.Now()
+					continue
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				if time.Since(refreshed) < s.LockRefresh.Timeout {
+					warden.Log.Warn().Msgf("unable to refresh lock: %+v", err)
+					continue
+				}
+
+				warden.Log.Error().Msgf("unable to refresh lock since %s, giving it up: %+v", refreshed.Local().Format(time.DateTime), err)
+				close(lost)
+				return
+			}
+		}
+	}()
+}
+
+// lockContext returns a context that is cancelled with ErrLockLost once the
+// held lock is given up
+func (s *Store) lockContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	s.locking.mu.Lock()
+	lost := s.locking.lost
+	s.locking.mu.Unlock()
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-lost:
+			cancel(ErrLockLost)
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() { cancel(nil) }
+}
+
+// Unlock releases the lock held by the store, if any
+func (s *Store) Unlock(ctx context.Context) error {
+	s.locking.mu.Lock()
+	cancel, stopped := s.locking.cancel, s.locking.stopped
+	s.locking.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-stopped
+
+	s.locking.mu.Lock()
+	defer s.locking.mu.Unlock()
+
+	lock := s.locking.lock
+	s.locking.lock, s.locking.cancel, s.locking.stopped, s.locking.lost = nil, nil, nil, nil
+
+	return s.removeLock(ctx, lock.ID)
+}
+
+// withLock runs fn holding at least the requested lock. A lock taken here is
+// released once fn returns. The context passed to fn is cancelled if the lock
+// is given up, and fn's error is then reported as ErrLockLost.
+func (s *Store) withLock(ctx context.Context, exclusive bool, fn func(ctx context.Context) error) error {
+	s.locking.mu.Lock()
+	held := s.locking.lock
+	s.locking.mu.Unlock()
+
+	if held != nil {
+		if exclusive && !held.Exclusive {
+			return ErrSharedLockHeld
+		}
+		return s.runLocked(ctx, fn)
+	}
+
+	err := s.Lock(ctx, exclusive)
+	if err != nil {
+		return err
+	}
+
+	err = s.runLocked(ctx, fn)
+	unlockErr := s.Unlock(context.WithoutCancel(ctx))
+	if err == nil {
+		err = unlockErr
+	}
+
+	return err
+}
+
+func (s *Store) runLocked(ctx context.Context, fn func(ctx context.Context) error) error {
+	lockCtx, cancel := s.lockContext(ctx)
+	defer cancel()
+
+	err := fn(lockCtx)
+	if err != nil && errors.Is(context.Cause(lockCtx), ErrLockLost) {
+		return fmt.Errorf("%w: %+v", ErrLockLost, err)
+	}
+
+	return err
+}
+
+// RemoveLocks removes stale locks, or every lock if all is set, except the
+// one held by the store itself
+func (s *Store) RemoveLocks(ctx context.Context, all bool) ([]Lock, error) {
+	locks, err := s.Locks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.locking.mu.Lock()
+	var own string
+	if s.locking.lock != nil {
+		own = s.locking.lock.ID
+	}
+	s.locking.mu.Unlock()
+
+	var removed []Lock
+	for _, lock := range locks {
+		if lock.ID == own || !all && !lock.Stale() {
+			continue
+		}
+
+		err = s.removeLock(ctx, lock.ID)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, lock)
+	}
+
+	return removed, nil
+}
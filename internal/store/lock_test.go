@@ -0,0 +1,283 @@
+package store_test
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/julianstephens/warden/internal/backend"
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func openTestStore(ctx context.Context, t *testing.T) *store.Store {
+	s, err := store.OpenStore(ctx, testDir, crypto.StaticPassword(testPwd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.LockRetry = store.LockRetry{}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+func TestLock(t *testing.T) {
+	tests := []struct {
+		name      string
+		first     bool
+		second    bool
+		conflicts bool
+	}{
+		{name: "shared and shared", first: false, second: false},
+		{name: "shared and exclusive", first: false, second: true, conflicts: true},
+		{name: "exclusive and shared", first: true, second: false, conflicts: true},
+		{name: "exclusive and exclusive", first: true, second: true, conflicts: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStore(t)
+
+			ctx := context.Background()
+			createAndInitStore(ctx, t)
+			first, second := openTestStore(ctx, t), openTestStore(ctx, t)
+
+			err := first.Lock(ctx, tt.first)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = second.Lock(ctx, tt.second)
+			if tt.conflicts != errors.Is(err, store.ErrStoreLocked) {
+				t.Fatalf("expected conflict %v, got %v", tt.conflicts, err)
+			}
+
+			locks, err := first.Locks(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := 2
+			if tt.conflicts {
+				expected = 1
+			}
+			if len(locks) != expected {
+				t.Fatalf("expected %d locks, got %d", expected, len(locks))
+			}
+
+			first.Close()
+			second.Close()
+			if files := listFiles(t, path.Join(testDir, "locks")); len(files) != 0 {
+				t.Fatalf("expected locks to be released on close, got %v", files)
+			}
+		})
+	}
+}
+
+func TestLockRetry(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	createAndInitStore(ctx, t)
+	first, second := openTestStore(ctx, t), openTestStore(ctx, t)
+
+	err := first.Lock(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = second.Backup(ctx, createBackupDir(t), store.BackupOptions{})
+	if !errors.Is(err, store.ErrStoreLocked) {
+		t.Fatalf("expected %v, got %v", store.ErrStoreLocked, err)
+	}
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		first.Unlock(ctx)
+	}()
+
+	second.LockRetry = store.LockRetry{Timeout: 5 * time.Second, InitialDelay: 100 * time.Millisecond, MaxDelay: 200 * time.Millisecond}
+	start := time.Now()
+	err = second.Backup(ctx, createBackupDir(t), store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 300*time.Millisecond {
+		t.Fatal("expected backup to wait for the exclusive lock")
+	}
+}
+
+func TestRefreshLock(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	createAndInitStore(ctx, t)
+	s := openTestStore(ctx, t)
+
+	err := s.Lock(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := s.Locks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.RefreshLock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := s.Locks(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(before) != 1 || len(after) != 1 || before[0].ID == after[0].ID || after[0].CreatedAt.Before(before[0].CreatedAt) {
+		t.Fatalf("expected refresh to replace the lock, got %v then %v", before, after)
+	}
+}
+
+// failingLocks fails to save locks once fail is set
+type failingLocks struct {
+	common.Backend
+	fail atomic.Bool
+}
+
+func (b *failingLocks) Save(ctx context.Context, event common.Event, reader common.IReader) error {
+	if event.Type == common.Lock && b.fail.Load() {
+		return errors.New("lock save failed")
+	}
+
+	return b.Backend.Save(ctx, event, reader)
+}
+
+// endlessReader returns random data until the test ends, failing lock saves
+// once the backup starts reading
+type endlessReader struct {
+	be *failingLocks
+}
+
+func (r endlessReader) Read(p []byte) (int, error) {
+	r.be.fail.Store(true)
+	time.Sleep(time.Millisecond)
+	return rand.Read(p)
+}
+
+func TestLockLost(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	createAndInitStore(ctx, t)
+
+	be, err := backend.NewBackend(ctx, common.LocalStorage, common.LocalStorageParams{Location: testDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := &failingLocks{Backend: be}
+
+	s, err := store.Open(ctx, failing, testDir, crypto.StaticPassword(testPwd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.LockRefresh = store.LockRefresh{Interval: 10 * time.Millisecond, Timeout: 50 * time.Millisecond}
+	t.Cleanup(func() { s.Close() })
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.BackupReader(ctx, endlessReader{be: failing}, "endless", store.BackupOptions{})
+	}()
+
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("expected backup to abort once its lock could not be refreshed")
+	}
+	if !errors.Is(err, store.ErrLockLost) {
+		t.Fatalf("expected error %+v, got %+v", store.ErrLockLost, err)
+	}
+
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 0 {
+		t.Fatalf("expected no snapshots, got %d", len(snaps))
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pid of an exited process
+	cmd := exec.Command("true")
+	if err = cmd.Run(); err != nil {
+		t.Skip("unable to run true:", err)
+	}
+	exited := cmd.ProcessState.Pid()
+
+	tests := []struct {
+		name  string
+		lock  store.Lock
+		stale bool
+	}{
+		{name: "live", lock: store.Lock{Hostname: hostname, PID: os.Getpid(), CreatedAt: time.Now()}},
+		{name: "other host", lock: store.Lock{Hostname: hostname + "-other", PID: exited, CreatedAt: time.Now()}},
+		{name: "exited process", lock: store.Lock{Hostname: hostname, PID: exited, CreatedAt: time.Now()}, stale: true},
+		{
+			name:  "not refreshed",
+			lock:  store.Lock{Hostname: hostname, PID: os.Getpid(), CreatedAt: time.Now().Add(-store.LockStaleTimeout - time.Minute)},
+			stale: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if stale := tt.lock.Stale(); stale != tt.stale {
+				t.Fatalf("expected stale %v, got %v", tt.stale, stale)
+			}
+		})
+	}
+}
+
+func TestRemoveLocks(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	createAndInitStore(ctx, t)
+	first, second := openTestStore(ctx, t), openTestStore(ctx, t)
+
+	err := first.Lock(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := second.RemoveLocks(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 0 {
+		t.Fatalf("expected live lock to be kept, got %v", removed)
+	}
+
+	removed, err = second.RemoveLocks(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 {
+		t.Fatalf("expected 1 lock removed, got %v", removed)
+	}
+
+	_, err = second.Prune(ctx, store.PruneOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
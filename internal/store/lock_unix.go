@@ -0,0 +1,15 @@
+//go:build !windows
+
+package store
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processExists reports whether a process with the pid runs on this host.
+// Signal 0 only checks that the process can be signalled.
+func processExists(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
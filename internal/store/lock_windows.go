@@ -0,0 +1,9 @@
+//go:build windows
+
+package store
+
+// processExists cannot check processes on Windows, so locks taken on this
+// host only become stale once they are not refreshed
+func processExists(pid int) bool {
+	return true
+}
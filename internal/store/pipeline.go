@@ -0,0 +1,392 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/alecthomas/units"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/julianstephens/warden/internal/chunker"
+	"github.com/julianstephens/warden/internal/exclude"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+// BackupOptions bounds the concurrency and memory use of a backup and tags
+// its snapshot. Zero values fall back to DefaultBackupOptions.
+type BackupOptions struct {
+	// FileWorkers is the number of files read and chunked in parallel
+	FileWorkers int
+	// BlobWorkers is the number of chunks compressed and encrypted in parallel
+	BlobWorkers int
+	// Uploaders is the number of packs saved to the backend in parallel
+	Uploaders int
+	// MaxMemory caps the chunk data buffered between pipeline stages
+	MaxMemory int64
+	// Exclude selects the paths skipped while walking the backup dir
+	Exclude exclude.Options
+	// Tags are recorded in the snapshot
+	Tags []string
+}
+
+var DefaultBackupOptions = BackupOptions{
+	FileWorkers: 2,
+	BlobWorkers: runtime.NumCPU(),
+	Uploaders:   2,
+	MaxMemory:   int64(256 * units.MiB),
+}
+
+func (o BackupOptions) withDefaults() BackupOptions {
+	if o.FileWorkers <= 0 {
+		o.FileWorkers = DefaultBackupOptions.FileWorkers
+	}
+	if o.BlobWorkers <= 0 {
+		o.BlobWorkers = DefaultBackupOptions.BlobWorkers
+	}
+	if o.Uploaders <= 0 {
+		o.Uploaders = DefaultBackupOptions.Uploaders
+	}
+	if o.MaxMemory <= 0 {
+		o.MaxMemory = DefaultBackupOptions.MaxMemory
+	}
+	return o
+}
+
+type fileJob struct {
+	path string
+	// reader is read instead of opening path, for data streamed from stdin
+	reader io.Reader
+	meta   storage.PathMetadata
+}
+
+// fileSource queues the files of a backup for chunking
+type fileSource func(ctx context.Context, files chan<- fileJob) error
+
+type blobJob struct {
+	id   string
+	data []byte
+}
+
+type sealedBlob struct {
+	blob storage.Blob
+	size int64
+}
+
+// backupPipeline moves files through bounded stages:
+//
+//	walk -> chunk + hash -> compress + encrypt -> assemble packs -> upload
+//
+// Every stage stops as soon as any stage fails or the context is cancelled.
+type backupPipeline struct {
+	store   *Store
+	packer  *storage.Packer
+	chunker chunker.Options
+	opts    BackupOptions
+	filter  *exclude.Filter
+	mem     *semaphore.Weighted
+
+	mu    sync.Mutex
+	paths []storage.PathMetadata
+}
+
+func newBackupPipeline(store *Store, packer *storage.Packer, chunkerOpts chunker.Options, backupDir string, opts BackupOptions) (*backupPipeline, error) {
+	opts = opts.withDefaults()
+
+	filter, err := exclude.NewFilter(backupDir, opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backupPipeline{
+		store:   store,
+		packer:  packer,
+		chunker: chunkerOpts,
+		opts:    opts,
+		filter:  filter,
+		mem:     semaphore.NewWeighted(opts.MaxMemory),
+	}, nil
+}
+
+// run backs up every file queued by source and returns their metadata
+func (p *backupPipeline) run(ctx context.Context, source fileSource) ([]storage.PathMetadata, error) {
+	g, ctx := errgroup.WithContext(ctx)
+
+	files := make(chan fileJob, p.opts.FileWorkers)
+	blobs := make(chan blobJob, p.opts.BlobWorkers)
+	sealed := make(chan sealedBlob, p.opts.BlobWorkers)
+	packs := make(chan *storage.Pack, p.opts.Uploaders)
+
+	g.Go(func() error {
+		defer close(files)
+		return source(ctx, files)
+	})
+
+	runStage(g, p.opts.FileWorkers, func() error {
+		return p.chunkFiles(ctx, files, blobs)
+	}, func() { close(blobs) })
+
+	runStage(g, p.opts.BlobWorkers, func() error {
+		return p.sealBlobs(ctx, blobs, sealed)
+	}, func() { close(sealed) })
+
+	g.Go(func() error {
+		defer close(packs)
+		return p.assemblePacks(ctx, sealed, packs)
+	})
+
+	runStage(g, p.opts.Uploaders, func() error {
+		for pack := range packs {
+			if err := savePack(p.store, ctx, pack); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func() {})
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return p.paths, nil
+}
+
+// runStage starts n workers and calls done once all of them have returned
+func runStage(g *errgroup.Group, n int, worker func() error, done func()) {
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		g.Go(func() error {
+			defer wg.Done()
+			return worker()
+		})
+	}
+
+	g.Go(func() error {
+		wg.Wait()
+		done()
+		return nil
+	})
+}
+
+func send[T any](ctx context.Context, ch chan<- T, v T) error {
+	select {
+	case ch <- v:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *backupPipeline) addPath(meta storage.PathMetadata) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.paths = append(p.paths, meta)
+}
+
+// walk queues every file under backupDir that changed since the latest
+// snapshot. Unchanged files reuse its chunk lists.
+func (p *backupPipeline) walk(ctx context.Context, latestSnapshot *storage.Snapshot, backupDir string, files chan<- fileJob) error {
+	previous := make(map[string]storage.PathMetadata)
+	if latestSnapshot != nil {
+		for _, path := range latestSnapshot.Paths {
+			previous[path.Path] = path
+		}
+	}
+
+	queued, copied, excluded := 0, 0, 0
+	err := filepath.WalkDir(backupDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(backupDir, path)
+		if err != nil {
+			return err
+		}
+
+		// the backup dir itself is the restore target, so it has no node
+		if rel == "." {
+			return nil
+		}
+
+		reason, err := p.filter.Excluded(path, rel, entry)
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+			warden.Log.Debug().Msgf("excluding %s, matched %q", filepath.ToSlash(rel), reason)
+			excluded++
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		entryInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		meta, err := storage.NewPathMetadata(path, rel, entryInfo)
+		if err != nil {
+			return err
+		}
+
+		if !meta.IsFile() {
+			p.addPath(meta)
+			return nil
+		}
+
+		if prev, ok := previous[meta.Path]; ok && prev.IsFile() && prev.FileSize == meta.FileSize && prev.ModifiedAt.Equal(meta.ModifiedAt) {
+			meta.Chunks = prev.Chunks
+			p.addPath(meta)
+			copied++
+			return nil
+		}
+
+		queued++
+		return send(ctx, files, fileJob{path: path, meta: meta})
+	})
+	warden.Log.Debug().Msgf("found %d paths to backup, %d unchanged, %d excluded", queued, copied, excluded)
+
+	return err
+}
+
+func (p *backupPipeline) chunkFiles(ctx context.Context, files <-chan fileJob, blobs chan<- blobJob) error {
+	for job := range files {
+		chunks, size, err := p.chunkFile(ctx, job, blobs)
+		if err != nil {
+			return err
+		}
+
+		// files removed since the walk have no chunk list
+		if chunks == nil {
+			continue
+		}
+
+		// the size read is recorded, since a file may change while it is read
+		job.meta.Chunks = chunks
+		job.meta.FileSize = size
+		p.addPath(job.meta)
+	}
+
+	return nil
+}
+
+// chunkFile splits the content of a file into chunks, queues those not yet
+// stored and returns the chunk list and the number of bytes read
+func (p *backupPipeline) chunkFile(ctx context.Context, job fileJob, blobs chan<- blobJob) (chunks []string, size int64, err error) {
+	r := job.reader
+	if r == nil {
+		file, err := os.Open(job.path)
+		if os.IsNotExist(err) {
+			warden.Log.Info().Msgf("file %s does not exist. skipping...", job.path)
+			return nil, 0, nil
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	warden.Log.Debug().Msgf("chunking and hashing %s...", job.path)
+	cKr := chunker.NewChunker(r, p.chunker)
+
+	chunks = []string{}
+	for {
+		var chunk chunker.Chunk
+		chunk, err = cKr.Next()
+		if err == io.EOF {
+			return chunks, size, nil
+		}
+		if err != nil {
+			return
+		}
+
+		size += int64(len(chunk.Data))
+		hashedChunk := p.store.chunkID(chunk.Data)
+		chunks = append(chunks, hashedChunk)
+		if !p.store.index.AddPending(hashedChunk) {
+			warden.Log.Debug().Msgf("chunk %s already stored. skipping...", hashedChunk)
+			continue
+		}
+
+		err = p.mem.Acquire(ctx, p.weight(len(chunk.Data)))
+		if err != nil {
+			return
+		}
+
+		// the chunker reuses its buffer, so the chunk must be copied
+		err = send(ctx, blobs, blobJob{id: hashedChunk, data: bytes.Clone(chunk.Data)})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *backupPipeline) sealBlobs(ctx context.Context, blobs <-chan blobJob, sealed chan<- sealedBlob) error {
+	for job := range blobs {
+		blob, err := p.packer.Seal(job.id, job.data)
+		if err != nil {
+			return err
+		}
+
+		err = send(ctx, sealed, sealedBlob{blob: blob, size: p.weight(len(job.data))})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// assemblePacks appends sealed blobs to packs and queues full packs for upload
+func (p *backupPipeline) assemblePacks(ctx context.Context, sealed <-chan sealedBlob, packs chan<- *storage.Pack) error {
+	for s := range sealed {
+		p.packer.AddBlob(s.blob)
+		p.mem.Release(s.size)
+
+		if !p.packer.Full() {
+			continue
+		}
+
+		pack, err := p.packer.Finalize()
+		if err != nil {
+			return err
+		}
+
+		err = send(ctx, packs, pack)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Err(); err != nil || p.packer.Count() == 0 {
+		return err
+	}
+
+	pack, err := p.packer.Finalize()
+	if err != nil {
+		return err
+	}
+
+	return send(ctx, packs, pack)
+}
+
+// weight returns the memory reserved for a chunk, capped so a single chunk
+// larger than the limit cannot block forever
+func (p *backupPipeline) weight(size int) int64 {
+	return min(int64(size), p.opts.MaxMemory)
+}
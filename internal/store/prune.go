@@ -0,0 +1,325 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+// PruneOptions limits how much data a prune rewrites
+type PruneOptions struct {
+	// MaxUnused is the unused space, as a fraction of the used space, that
+	// may be left in partly used packs. Packs with the most unused space are
+	// repacked until the rest is below the limit.
+	MaxUnused float64
+	// MaxRepackSize caps the size of the packs repacked, 0 for no limit
+	MaxRepackSize int64
+	// DryRun plans the prune without changing the store
+	DryRun bool
+}
+
+// PruneResult describes the packs and blobs a prune found and what it did
+// with them. Sizes are the encrypted sizes of blobs.
+type PruneResult struct {
+	Packs       int
+	UsedBlobs   int
+	UnusedBlobs int
+	UsedSize    int64
+	UnusedSize  int64
+
+	// RemovedPacks holds packs without used blobs, including packs no index
+	// file refers to
+	RemovedPacks []string
+	// RepackedPacks holds partly used packs whose used blobs were copied to
+	// new packs
+	RepackedPacks []string
+	RepackedBlobs int
+	RepackedSize  int64
+	NewPacks      int
+	// RemainingUnused is the unused space left in kept packs
+	RemainingUnused int64
+	// IndexFiles is the number of index files replaced by a new one
+	IndexFiles int
+}
+
+// packUsage sums up the used and unused blobs of a pack
+type packUsage struct {
+	name       string
+	locs       []storage.ChunkLoc
+	used       []storage.ChunkLoc
+	usedSize   int64
+	unusedSize int64
+}
+
+// Prune removes blobs no snapshot references. Packs without used blobs are
+// removed, and partly used packs are repacked within the limits of opts.
+//
+// Every step leaves a consistent store: new packs are written first, then an
+// index file covering every kept blob, and only then are the old index files
+// and packs removed. An interrupted prune leaves at most unreferenced packs
+// or duplicate index entries, which the next prune cleans up.
+//
+// A prune holds an exclusive lock, or a shared one for a dry run.
+func (s *Store) Prune(ctx context.Context, opts PruneOptions) (result *PruneResult, err error) {
+	err = s.withLock(ctx, !opts.DryRun, func(ctx context.Context) error {
+		result, err = s.prune(ctx, opts)
+		return err
+	})
+
+	return
+}
+
+func (s *Store) prune(ctx context.Context, opts PruneOptions) (*PruneResult, error) {
+	used, err := s.usedChunks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	indexFiles, err := s.backend.List(ctx, common.Index)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list index files: %+v", err)
+	}
+
+	byPack := make(map[string][]storage.ChunkLoc)
+	for _, name := range indexFiles {
+		file, err := s.loadIndexFile(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, loc := range file.Chunks {
+			byPack[loc.Pack] = append(byPack[loc.Pack], loc)
+		}
+	}
+
+	packNames, err := s.backend.List(ctx, common.Pack)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list packs: %+v", err)
+	}
+	sort.Strings(packNames)
+
+	result := &PruneResult{Packs: len(packNames), IndexFiles: len(indexFiles)}
+	for _, name := range packNames {
+		if _, ok := byPack[name]; !ok {
+			result.RemovedPacks = append(result.RemovedPacks, name)
+		}
+	}
+
+	// each used chunk is kept once, in the first pack holding it
+	found := make(map[string]struct{}, len(used))
+	var packs []*packUsage
+	for _, name := range packNames {
+		locs, ok := byPack[name]
+		if !ok {
+			continue
+		}
+
+		usage := &packUsage{name: name, locs: locs}
+		for _, loc := range locs {
+			if _, ok := used[loc.Chunk]; ok {
+				if _, dup := found[loc.Chunk]; !dup {
+					found[loc.Chunk] = struct{}{}
+					usage.used = append(usage.used, loc)
+					usage.usedSize += loc.Length()
+					continue
+				}
+			}
+			usage.unusedSize += loc.Length()
+		}
+
+		result.UsedBlobs += len(usage.used)
+		result.UnusedBlobs += len(locs) - len(usage.used)
+		result.UsedSize += usage.usedSize
+		result.UnusedSize += usage.unusedSize
+		packs = append(packs, usage)
+	}
+
+	// never prune a store that is already missing data, e.g. a pack
+	for chunk := range used {
+		if _, ok := found[chunk]; !ok {
+			return nil, fmt.Errorf("%w: %s is referenced by a snapshot but not stored, run check", ErrChunkNotFound, chunk)
+		}
+	}
+
+	var kept, candidates []*packUsage
+	for _, usage := range packs {
+		switch {
+		case len(usage.used) == 0:
+			result.RemovedPacks = append(result.RemovedPacks, usage.name)
+		case usage.unusedSize == 0:
+			kept = append(kept, usage)
+		default:
+			candidates = append(candidates, usage)
+			result.RemainingUnused += usage.unusedSize
+		}
+	}
+
+	// repack the most wasteful packs first
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].unusedSize > candidates[j].unusedSize
+	})
+
+	maxUnused := int64(opts.MaxUnused * float64(result.UsedSize))
+	var repack []*packUsage
+	for _, usage := range candidates {
+		size := usage.usedSize + usage.unusedSize
+		if result.RemainingUnused <= maxUnused || opts.MaxRepackSize > 0 && result.RepackedSize+size > opts.MaxRepackSize {
+			kept = append(kept, usage)
+			continue
+		}
+
+		repack = append(repack, usage)
+		result.RepackedPacks = append(result.RepackedPacks, usage.name)
+		result.RepackedBlobs += len(usage.used)
+		result.RepackedSize += size
+		result.RemainingUnused -= usage.unusedSize
+	}
+
+	if opts.DryRun || len(result.RemovedPacks) == 0 && len(repack) == 0 {
+		return result, nil
+	}
+
+	index := storage.NewIndex()
+	for _, usage := range kept {
+		index.AddPack(usage.name, headerOf(usage.locs))
+	}
+
+	newPacks, err := s.repack(ctx, repack, index)
+	if err != nil {
+		return nil, err
+	}
+	result.NewPacks = newPacks
+
+	err = s.replaceIndex(ctx, index, indexFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range append(result.RemovedPacks, result.RepackedPacks...) {
+		warden.Log.Debug().Msgf("removing pack %s", name)
+		err = s.backend.Remove(ctx, common.Event{Type: common.Pack, Name: &name})
+		if err != nil {
+			return nil, fmt.Errorf("unable to remove pack %s: %+v", name, err)
+		}
+	}
+
+	return result, nil
+}
+
+// usedChunks collects the chunks referenced by every snapshot
+func (s *Store) usedChunks(ctx context.Context) (map[string]struct{}, error) {
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]struct{})
+	for _, snap := range snaps {
+		for _, meta := range snap.Paths {
+			for _, chunk := range meta.Chunks {
+				used[chunk] = struct{}{}
+			}
+		}
+	}
+
+	return used, nil
+}
+
+// repack copies the used blobs of packs into new packs and adds them to
+// index. Blobs are verified but not re-encrypted.
+func (s *Store) repack(ctx context.Context, packs []*packUsage, index *storage.Index) (int, error) {
+	key := *s.master.master
+	packer := storage.NewPacker(key, nil, int(storage.DefaultPackSize))
+	written := 0
+
+	flush := func() error {
+		pack, err := packer.Finalize()
+		if err != nil {
+			return err
+		}
+
+		name := pack.ID.String()
+		warden.Log.Debug().Msgf("saving repacked pack %s with %d blobs...", name, len(pack.Header.Blobs))
+		err = s.backend.Save(ctx, common.Event{Type: common.Pack, Name: &name}, common.NewHashedByteReader(pack.Data, pack.ID[:]))
+		if err != nil {
+			return fmt.Errorf("unable to save pack %s: %+v", name, err)
+		}
+		index.AddPack(name, pack.Header)
+		written++
+
+		return nil
+	}
+
+	for _, usage := range packs {
+		data, err := s.backend.Load(ctx, common.Event{Type: common.Pack, Name: &usage.name}, 0, 0)
+		if err != nil {
+			return written, fmt.Errorf("unable to load pack %s: %+v", usage.name, err)
+		}
+		if crypto.Hash(data).String() != usage.name {
+			return written, fmt.Errorf("%w: pack %s", ErrContentHash, usage.name)
+		}
+
+		for _, loc := range usage.used {
+			if loc.ChunkStart < 0 || loc.ChunkEnd > int64(len(data)) || loc.ChunkStart > loc.ChunkEnd {
+				return written, fmt.Errorf("%w: %s out of range of pack %s", storage.ErrInvalidPack, loc.Chunk, usage.name)
+			}
+			sealed := data[loc.ChunkStart:loc.ChunkEnd]
+
+			plain, err := storage.OpenBlob(key, loc.HeaderEntry(), sealed)
+			if err != nil {
+				return written, fmt.Errorf("%w: %+v", ErrChunkIntegrity, err)
+			}
+			if s.chunkID(plain) != loc.Chunk {
+				return written, fmt.Errorf("%w: %s", ErrChunkIntegrity, loc.Chunk)
+			}
+
+			packer.AddBlob(storage.Blob{ID: loc.Chunk, Type: loc.Type, Data: sealed})
+			if packer.Full() {
+				if err = flush(); err != nil {
+					return written, err
+				}
+			}
+		}
+	}
+
+	if packer.Count() > 0 {
+		if err := flush(); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// replaceIndex saves index as a new index file, then removes the old ones
+func (s *Store) replaceIndex(ctx context.Context, index *storage.Index, old []string) error {
+	s.index = index
+	err := s.saveIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range old {
+		warden.Log.Debug().Msgf("removing index file %s", name)
+		err = s.backend.Remove(ctx, common.Event{Type: common.Index, Name: &name})
+		if err != nil {
+			return fmt.Errorf("unable to remove index file %s: %+v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func headerOf(locs []storage.ChunkLoc) storage.Header {
+	header := storage.Header{Blobs: make([]storage.HeaderEntry, 0, len(locs))}
+	for _, loc := range locs {
+		header.Blobs = append(header.Blobs, loc.HeaderEntry())
+	}
+
+	return header
+}
@@ -0,0 +1,115 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func writeRandomFile(t *testing.T, p string, size int) []byte {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestPrune(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          store.PruneOptions
+		repacked      int
+		expectedPacks int
+		expectedIndex int
+	}{
+		{name: "repack", opts: store.PruneOptions{}, repacked: 1, expectedPacks: 1, expectedIndex: 1},
+		{name: "within max unused", opts: store.PruneOptions{MaxUnused: 1}, expectedPacks: 1, expectedIndex: 1},
+		{name: "max repack size", opts: store.PruneOptions{MaxRepackSize: 1024}, expectedPacks: 1, expectedIndex: 1},
+		{name: "dry run", opts: store.PruneOptions{DryRun: true}, repacked: 1, expectedPacks: 2, expectedIndex: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStore(t)
+
+			ctx := context.Background()
+			s := createAndInitStore(ctx, t)
+
+			// the first snapshot of dir is partly superseded by the last one,
+			// the snapshot of other is forgotten entirely
+			dir, other := t.TempDir(), t.TempDir()
+			kept := writeRandomFile(t, path.Join(dir, "keep.bin"), 128*1024)
+			writeRandomFile(t, path.Join(dir, "drop.bin"), 64*1024)
+			writeRandomFile(t, path.Join(other, "other.bin"), 32*1024)
+
+			for _, dir := range []string{dir, other} {
+				if err := s.Backup(ctx, dir, store.BackupOptions{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := os.Remove(path.Join(dir, "drop.bin")); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Backup(ctx, dir, store.BackupOptions{Tags: []string{"keep"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := s.Forget(ctx, store.ForgetOptions{Policy: store.RetentionPolicy{Tags: []string{"keep"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := s.Prune(ctx, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result.Packs != 2 || len(result.RemovedPacks) != 1 || len(result.RepackedPacks) != tt.repacked {
+				t.Fatalf("expected 2 packs, 1 removed and %d repacked, got %+v", tt.repacked, result)
+			}
+			if result.UsedBlobs == 0 || result.UnusedBlobs == 0 {
+				t.Fatalf("expected used and unused blobs, got %+v", result)
+			}
+
+			if packs := listFiles(t, path.Join(testDir, "packs")); len(packs) != tt.expectedPacks {
+				t.Fatalf("expected %d packs, got %d", tt.expectedPacks, len(packs))
+			}
+			if index := listFiles(t, path.Join(testDir, "index")); len(index) != tt.expectedIndex {
+				t.Fatalf("expected %d index files, got %d", tt.expectedIndex, len(index))
+			}
+
+			check, err := s.Check(ctx, store.CheckOptions{ReadData: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = check.Err(); err != nil {
+				t.Fatalf("expected no errors, got %v", check.Errors)
+			}
+			if !tt.opts.DryRun && len(check.Unreferenced) > 0 {
+				t.Fatalf("expected no unreferenced packs, got %v", check.Unreferenced)
+			}
+
+			target := t.TempDir()
+			err = s.Restore(ctx, "latest", target, store.RestoreOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			restored, err := os.ReadFile(path.Join(target, "keep.bin"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(restored, kept) {
+				t.Fatal("expected restored keep.bin to match the original")
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+type RestoreOptions struct {
+	// Include restricts the restore to paths matching at least one pattern
+	Include []string
+	// Exclude skips paths matching any pattern
+	Exclude []string
+}
+
+var (
+	ErrChunkNotFound  = errors.New("chunk not found in index")
+	ErrChunkIntegrity = errors.New("chunk failed integrity check")
+)
+
+// Restore rebuilds the nodes of a snapshot under target
+func (s *Store) Restore(ctx context.Context, snapshotID string, target string, opts RestoreOptions) error {
+	snap, err := s.FindSnapshot(ctx, snapshotID)
+	if err != nil {
+		return err
+	}
+
+	err = warden.EnsureDir(target)
+	if err != nil {
+		return fmt.Errorf("unable to create restore target %s: %+v", target, err)
+	}
+
+	r := &restorer{loader: newBlobLoader(s), links: make(map[inodeKey]string)}
+	var dirs []storage.PathMetadata
+	restored := 0
+	for _, p := range snap.Paths {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+
+		if !opts.selected(p.Path) {
+			warden.Log.Debug().Msgf("skipping %s", p.Path)
+			continue
+		}
+
+		err = r.restoreNode(ctx, p, restorePath(target, p))
+		if err != nil {
+			return fmt.Errorf("unable to restore %s: %w", p.Path, err)
+		}
+
+		if p.Type == storage.NodeDir {
+			dirs = append(dirs, p)
+		}
+		restored++
+	}
+
+	// creating entries changes a directory's times, so directories are
+	// finished last, children before their parents
+	for i := len(dirs) - 1; i >= 0; i-- {
+		err = storage.RestoreMetadata(restorePath(target, dirs[i]), dirs[i])
+		if err != nil {
+			return fmt.Errorf("unable to restore %s: %w", dirs[i].Path, err)
+		}
+	}
+	warden.Log.Debug().Msgf("restored %d of %d nodes from snapshot %s", restored, len(snap.Paths), snap.ID)
+
+	return nil
+}
+
+func restorePath(target string, meta storage.PathMetadata) string {
+	return filepath.Join(target, filepath.FromSlash(meta.Path))
+}
+
+type inodeKey struct {
+	device uint64
+	inode  uint64
+}
+
+type restorer struct {
+	loader *blobLoader
+	// links maps hardlinked inodes to the first path restored for them
+	links map[inodeKey]string
+}
+
+func (r *restorer) restoreNode(ctx context.Context, meta storage.PathMetadata, dest string) (err error) {
+	warden.Log.Debug().Msgf("restoring %s...", dest)
+
+	err = warden.EnsureDir(filepath.Dir(dest))
+	if err != nil {
+		return
+	}
+
+	// a node of another type in the way is replaced, even a non-empty
+	// directory
+	want := meta.Type
+	if meta.IsFile() {
+		want = storage.NodeFile
+	}
+	if info, lerr := os.Lstat(dest); lerr == nil && nodeType(info.Mode()) != want {
+		warden.Log.Debug().Msgf("replacing %s of another type", dest)
+		err = os.RemoveAll(dest)
+		if err != nil {
+			return
+		}
+	}
+
+	if meta.Type == storage.NodeDir {
+		return warden.EnsureDir(dest)
+	}
+
+	err = os.Remove(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return
+	}
+
+	switch meta.Type {
+	case storage.NodeFile, "":
+		key := inodeKey{device: meta.Device, inode: meta.Inode}
+		if meta.Links > 1 {
+			if first, ok := r.links[key]; ok {
+				return os.Link(first, dest)
+			}
+		}
+
+		err = restoreFile(ctx, r.loader, meta, dest)
+		if err == nil && meta.Links > 1 {
+			r.links[key] = dest
+		}
+	case storage.NodeSymlink:
+		err = os.Symlink(meta.LinkTarget, dest)
+	default:
+		err = storage.MakeNode(dest, meta)
+	}
+	if err != nil {
+		return
+	}
+
+	return storage.RestoreMetadata(dest, meta)
+}
+
+// nodeType returns the node type of a file mode, or an empty type for modes
+// snapshots cannot hold
+func nodeType(mode fs.FileMode) storage.NodeType {
+	switch {
+	case mode.IsRegular():
+		return storage.NodeFile
+	case mode.IsDir():
+		return storage.NodeDir
+	case mode&fs.ModeSymlink != 0:
+		return storage.NodeSymlink
+	case mode&fs.ModeCharDevice != 0:
+		return storage.NodeCharDevice
+	case mode&fs.ModeDevice != 0:
+		return storage.NodeDevice
+	case mode&fs.ModeNamedPipe != 0:
+		return storage.NodeFIFO
+	case mode&fs.ModeSocket != 0:
+		return storage.NodeSocket
+	default:
+		return ""
+	}
+}
+
+func restoreFile(ctx context.Context, loader *blobLoader, meta storage.PathMetadata, dest string) (err error) {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	for _, id := range meta.Chunks {
+		var data []byte
+		data, err = loader.Load(ctx, id)
+		if err != nil {
+			return
+		}
+
+		_, err = f.Write(data)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// selected reports whether a snapshot path passes the include and exclude
+// filters
+func (o RestoreOptions) selected(p string) bool {
+	for _, pattern := range o.Exclude {
+		if matchPath(pattern, p) {
+			return false
+		}
+	}
+
+	if len(o.Include) == 0 {
+		return true
+	}
+
+	for _, pattern := range o.Include {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPath reports whether a glob pattern matches a path or any of its
+// parent directories, so a directory pattern selects its whole subtree.
+// Patterns without a slash match a file or directory name at any depth.
+func matchPath(pattern string, p string) bool {
+	pattern = strings.Trim(pattern, "/")
+	anyDepth := !strings.Contains(pattern, "/")
+
+	for prefix := p; prefix != "." && prefix != ""; prefix = path.Dir(prefix) {
+		if ok, _ := path.Match(pattern, prefix); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(prefix)); ok && anyDepth {
+			return true
+		}
+	}
+
+	return false
+}
+
+// blobLoader reads chunks from packs, keeping the most recently read pack in
+// memory since chunks of a file are usually stored together
+type blobLoader struct {
+	store  *Store
+	packID string
+	pack   []byte
+}
+
+func newBlobLoader(store *Store) *blobLoader {
+	return &blobLoader{store: store}
+}
+
+// Load decrypts a chunk and verifies it against its HMAC
+func (l *blobLoader) Load(ctx context.Context, id string) ([]byte, error) {
+	loc, ok := l.store.index.Lookup(id)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrChunkNotFound, id)
+	}
+
+	if l.packID != loc.Pack {
+		pack, err := l.store.backend.Load(ctx, common.Event{Type: common.Pack, Name: &loc.Pack}, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load pack %s: %+v", loc.Pack, err)
+		}
+		l.packID = loc.Pack
+		l.pack = pack
+	}
+
+	if loc.ChunkStart < 0 || loc.ChunkEnd > int64(len(l.pack)) || loc.ChunkStart > loc.ChunkEnd {
+		return nil, fmt.Errorf("%w: %s out of range of pack %s", storage.ErrInvalidPack, id, loc.Pack)
+	}
+
+	data, err := storage.OpenBlob(*l.store.master.master, loc.HeaderEntry(), l.pack[loc.ChunkStart:loc.ChunkEnd])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %+v", ErrChunkIntegrity, err)
+	}
+
+	if l.store.chunkID(data) != id {
+		return nil, fmt.Errorf("%w: %s", ErrChunkIntegrity, id)
+	}
+
+	return data, nil
+}
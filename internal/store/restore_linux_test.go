@@ -0,0 +1,188 @@
+//go:build linux
+
+package store_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func TestRestoreNodes(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := t.TempDir()
+	mustDo := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mustDo(os.WriteFile(path.Join(backupDir, "file"), []byte("contents"), 0640))
+	mustDo(os.Link(path.Join(backupDir, "file"), path.Join(backupDir, "hardlink")))
+	mustDo(os.Symlink("file", path.Join(backupDir, "symlink")))
+	mustDo(os.Symlink("/does/not/exist", path.Join(backupDir, "dangling")))
+	mustDo(unix.Mkfifo(path.Join(backupDir, "fifo"), 0600))
+	mustDo(os.Mkdir(path.Join(backupDir, "private"), 0700))
+	mustDo(os.Chmod(path.Join(backupDir, "private"), 0700|os.ModeSetgid))
+	mustDo(os.WriteFile(path.Join(backupDir, "private", "setuid"), []byte("#!/bin/sh\n"), 0755))
+	owned := os.Geteuid() == 0
+	if owned {
+		mustDo(os.Lchown(path.Join(backupDir, "private", "setuid"), 1234, 5678))
+	}
+	// changing the owner clears setuid, so it is set last
+	mustDo(os.Chmod(path.Join(backupDir, "private", "setuid"), 0755|os.ModeSetuid))
+	mustDo(os.Mkdir(path.Join(backupDir, "empty"), 0755))
+
+	xattrs := true
+	err := unix.Lsetxattr(path.Join(backupDir, "file"), "user.warden", []byte("value"), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		xattrs = false
+	} else {
+		mustDo(err)
+	}
+
+	dirTime := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	mustDo(os.Chtimes(path.Join(backupDir, "private"), dirTime, dirTime))
+	mustDo(os.Chtimes(path.Join(backupDir, "empty"), dirTime, dirTime))
+
+	err = s.Backup(ctx, backupDir, store.BackupOptions{})
+	mustDo(err)
+
+	target := t.TempDir()
+	err = s.Restore(ctx, "latest", target, store.RestoreOptions{})
+	mustDo(err)
+
+	var fileStat, linkStat syscall.Stat_t
+	mustDo(syscall.Stat(path.Join(target, "file"), &fileStat))
+	mustDo(syscall.Stat(path.Join(target, "hardlink"), &linkStat))
+	if fileStat.Ino != linkStat.Ino {
+		t.Fatalf("expected hardlink to share inode %d, got %d", fileStat.Ino, linkStat.Ino)
+	}
+
+	for name, expected := range map[string]string{"symlink": "file", "dangling": "/does/not/exist"} {
+		link, err := os.Readlink(path.Join(target, name))
+		mustDo(err)
+		if link != expected {
+			t.Fatalf("expected %s to point to %s, got %s", name, expected, link)
+		}
+	}
+
+	expectedModes := map[string]os.FileMode{
+		"file":           0640,
+		"fifo":           os.ModeNamedPipe | 0600,
+		"private":        os.ModeDir | os.ModeSetgid | 0700,
+		"private/setuid": os.ModeSetuid | 0755,
+		"empty":          os.ModeDir | 0755,
+	}
+	for name, expected := range expectedModes {
+		info, err := os.Lstat(path.Join(target, name))
+		mustDo(err)
+		if info.Mode() != expected {
+			t.Fatalf("expected %s to have mode %s, got %s", name, expected, info.Mode())
+		}
+	}
+
+	for _, name := range []string{"private", "empty"} {
+		info, err := os.Stat(path.Join(target, name))
+		mustDo(err)
+		if !info.ModTime().Equal(dirTime) {
+			t.Fatalf("expected %s mtime %s, got %s", name, dirTime, info.ModTime())
+		}
+	}
+
+	if xattrs {
+		buf := make([]byte, 64)
+		n, err := unix.Lgetxattr(path.Join(target, "file"), "user.warden", buf)
+		mustDo(err)
+		if string(buf[:n]) != "value" {
+			t.Fatalf("expected xattr value %q, got %q", "value", buf[:n])
+		}
+	}
+
+	if owned {
+		var st syscall.Stat_t
+		mustDo(syscall.Lstat(path.Join(target, "private", "setuid"), &st))
+		if st.Uid != 1234 || st.Gid != 5678 {
+			t.Fatalf("expected owner 1234:5678, got %d:%d", st.Uid, st.Gid)
+		}
+	}
+}
+
+func TestRestoreOverClashingTypes(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := createBackupDir(t)
+	if err := os.Symlink("a.txt", path.Join(backupDir, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := unix.Mkfifo(path.Join(backupDir, "fifo"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every node of the snapshot is blocked by a node of another type
+	target := t.TempDir()
+	for _, dir := range []string{"a.txt/full", "link/full"} {
+		if err = os.MkdirAll(path.Join(target, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(path.Join(target, dir, "file"), []byte("in the way"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.WriteFile(path.Join(target, "nested"), []byte("in the way"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Symlink("a.txt", path.Join(target, "fifo")); err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Restore(ctx, "latest", target, store.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{name: "a.txt", mode: 0},
+		{name: "link", mode: os.ModeSymlink},
+		{name: "nested", mode: os.ModeDir},
+		{name: "nested/c/d.bin", mode: 0},
+		{name: "fifo", mode: os.ModeNamedPipe},
+	}
+	for _, tt := range tests {
+		info, err := os.Lstat(path.Join(target, tt.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Type() != tt.mode {
+			t.Fatalf("expected %s to have type %v, got %v", tt.name, tt.mode, info.Mode().Type())
+		}
+	}
+
+	data, err := os.ReadFile(path.Join(target, "a.txt"))
+	if err != nil || string(data) != "hello, world" {
+		t.Fatalf("expected a.txt to be restored, got %q (%v)", data, err)
+	}
+}
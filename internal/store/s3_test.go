@@ -0,0 +1,95 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+	"github.com/rs/zerolog"
+
+	"github.com/julianstephens/warden/internal/backend"
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/store"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+func TestS3Store(t *testing.T) {
+	ctx := context.Background()
+	warden.SetLog(warden.NewLog(os.Stderr, zerolog.ErrorLevel, time.RFC1123))
+
+	server := httptest.NewTLSServer(gofakes3.New(s3mem.New()).Server())
+	defer server.Close()
+
+	params := common.S3StorageParams{
+		Endpoint:  strings.TrimPrefix(server.URL, "https://"),
+		Bucket:    "warden",
+		Prefix:    "store",
+		AccessKey: "access",
+		SecretKey: "secretsecret",
+		PathStyle: true,
+		Transport: server.Client().Transport,
+	}
+
+	// init creates the bucket, later sessions open it as is
+	initParams := params
+	initParams.Create = true
+	be, err := backend.NewBackend(ctx, common.S3, initParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := store.NewStore(be, "s3:warden/store")
+	err = s.Init(ctx, store.InitOptions{Params: crypto.DefaultParams}, testPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupDir := createBackupDir(t)
+	err = s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen through a fresh client, as a new session would
+	be, err = backend.NewBackend(ctx, common.S3, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := store.Open(ctx, be, "s3:warden/store", crypto.StaticPassword(testPwd))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := t.TempDir()
+	err = opened.Restore(ctx, "latest", target, store.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range listFiles(t, backupDir) {
+		rel, _ := filepath.Rel(backupDir, p)
+		original, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := os.ReadFile(path.Join(target, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(original, restored) {
+			t.Fatalf("restored %s does not match original", rel)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/warden"
+)
+
+var (
+	ErrNoSnapshots      = errors.New("store has no snapshots")
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
+// ListSnapshots loads and decrypts every snapshot in the store
+func (s *Store) ListSnapshots(ctx context.Context) ([]storage.Snapshot, error) {
+	names, err := s.backend.List(ctx, common.Snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list snapshots: %+v", err)
+	}
+
+	snaps := make([]storage.Snapshot, 0, len(names))
+	for _, name := range names {
+		snap, err := s.loadSnapshot(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, *snap)
+	}
+
+	return snaps, nil
+}
+
+// FindSnapshot returns the snapshot with the given ID or unique ID prefix.
+// "latest" selects the most recently created snapshot.
+func (s *Store) FindSnapshot(ctx context.Context, id string) (*storage.Snapshot, error) {
+	if id == "latest" {
+		snaps, err := s.ListSnapshots(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(snaps) == 0 {
+			return nil, ErrNoSnapshots
+		}
+
+		sort.Slice(snaps, func(i, j int) bool {
+			return snaps[i].CreatedAt.After(snaps[j].CreatedAt)
+		})
+		return &snaps[0], nil
+	}
+
+	names, err := s.backend.List(ctx, common.Snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list snapshots: %+v", err)
+	}
+
+	matches := warden.Filter(names, func(name string) bool {
+		return strings.HasPrefix(name, id)
+	})
+
+	switch {
+	case id == "" || len(matches) == 0:
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, id)
+	case len(matches) > 1:
+		return nil, fmt.Errorf("snapshot id %q is ambiguous, matches %d snapshots", id, len(matches))
+	}
+
+	return s.loadSnapshot(ctx, matches[0])
+}
+
+func (s *Store) loadSnapshot(ctx context.Context, name string) (*storage.Snapshot, error) {
+	data, err := s.backend.Load(ctx, common.Event{Type: common.Snapshot, Name: &name}, 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load snapshot %s: %+v", name, err)
+	}
+
+	decrypted, err := crypto.Decrypt(*s.master.master, data, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt snapshot %s: %+v", name, err)
+	}
+
+	var snap storage.Snapshot
+	err = json.Unmarshal(decrypted, &snap)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse snapshot %s: %+v", name, err)
+	}
+	snap.ID = name
+
+	return &snap, nil
+}
+
+// saveSnapshot encrypts a snapshot and saves it under the hash of its contents
+func (s *Store) saveSnapshot(ctx context.Context, snap *storage.Snapshot) error {
+	snapJson, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("unable to marshal snapshot: %+v", err)
+	}
+
+	encrypted, err := crypto.Encrypt(*s.master.master, snapJson, nil)
+	if err != nil {
+		return fmt.Errorf("unable to encrypt snapshot: %+v", err)
+	}
+
+	id := crypto.Hash(encrypted)
+	name := id.String()
+	warden.Log.Debug().Msgf("saving snapshot %s...", name)
+	err = s.backend.Save(ctx, common.Event{Type: common.Snapshot, Name: &name}, common.NewHashedByteReader(encrypted, id[:]))
+	if err != nil {
+		return fmt.Errorf("unable to save snapshot %s: %+v", name, err)
+	}
+	snap.ID = name
+	warden.Log.Debug().Msg("snapshot saved.")
+
+	return nil
+}
+
+// SnapshotFilter selects snapshots. Empty fields match every snapshot.
+type SnapshotFilter struct {
+	Hosts []string
+	// Paths match the backup volume
+	Paths []string
+	// Tags match snapshots with any of the tags
+	Tags  []string
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether a snapshot passes the filter
+func (f SnapshotFilter) Match(snap storage.Snapshot) bool {
+	switch {
+	case len(f.Hosts) > 0 && !slices.Contains(f.Hosts, snap.Hostname):
+		return false
+	case len(f.Paths) > 0 && !slices.Contains(f.Paths, snap.BackupVolume):
+		return false
+	case len(f.Tags) > 0 && !slices.ContainsFunc(f.Tags, func(tag string) bool { return slices.Contains(snap.Tags, tag) }):
+		return false
+	case !f.Since.IsZero() && snap.CreatedAt.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && snap.CreatedAt.After(f.Until):
+		return false
+	}
+
+	return true
+}
+
+// FindSnapshots returns the snapshots passing filter, oldest first
+func (s *Store) FindSnapshots(ctx context.Context, filter SnapshotFilter) ([]storage.Snapshot, error) {
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	snaps = warden.Filter(snaps, filter.Match)
+	sort.SliceStable(snaps, func(i, j int) bool {
+		return snaps[i].CreatedAt.Before(snaps[j].CreatedAt)
+	})
+
+	return snaps, nil
+}
+
+// GroupBy selects the snapshot fields snapshots are grouped by
+type GroupBy struct {
+	Host bool
+	Path bool
+}
+
+// SnapshotGroup holds the snapshots of one host and path. Host or Path is
+// empty when not grouped by it.
+type SnapshotGroup struct {
+	Host      string
+	Path      string
+	Snapshots []storage.Snapshot
+}
+
+// GroupSnapshots groups snapshots by host and/or path, keeping their order.
+// Groups are sorted by host, then path.
+func GroupSnapshots(snaps []storage.Snapshot, by GroupBy) []SnapshotGroup {
+	type key struct{ host, path string }
+
+	var keys []key
+	groups := make(map[key]*SnapshotGroup)
+	for _, snap := range snaps {
+		k := key{}
+		if by.Host {
+			k.host = snap.Hostname
+		}
+		if by.Path {
+			k.path = snap.BackupVolume
+		}
+
+		g, ok := groups[k]
+		if !ok {
+			g = &SnapshotGroup{Host: k.host, Path: k.path}
+			groups[k] = g
+			keys = append(keys, k)
+		}
+		g.Snapshots = append(g.Snapshots, snap)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].host != keys[j].host {
+			return keys[i].host < keys[j].host
+		}
+		return keys[i].path < keys[j].path
+	})
+
+	result := make([]SnapshotGroup, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, *groups[k])
+	}
+
+	return result
+}
+
+// Latest keeps the n most recent snapshots of the group
+func (g SnapshotGroup) Latest(n int) SnapshotGroup {
+	snaps := slices.Clone(g.Snapshots)
+	sort.SliceStable(snaps, func(i, j int) bool {
+		return snaps[i].CreatedAt.Before(snaps[j].CreatedAt)
+	})
+	if n >= 0 && n < len(snaps) {
+		snaps = snaps[len(snaps)-n:]
+	}
+	g.Snapshots = snaps
+
+	return g
+}
@@ -0,0 +1,110 @@
+package store_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/julianstephens/warden/internal/storage"
+	"github.com/julianstephens/warden/internal/store"
+)
+
+func TestSnapshotFilter(t *testing.T) {
+	created := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	snap := storage.Snapshot{Hostname: "alpha", BackupVolume: "/data", Tags: []string{"daily", "db"}, CreatedAt: created}
+
+	tests := []struct {
+		name     string
+		filter   store.SnapshotFilter
+		expected bool
+	}{
+		{name: "empty", expected: true},
+		{name: "host", filter: store.SnapshotFilter{Hosts: []string{"beta", "alpha"}}, expected: true},
+		{name: "other host", filter: store.SnapshotFilter{Hosts: []string{"beta"}}},
+		{name: "path", filter: store.SnapshotFilter{Paths: []string{"/data"}}, expected: true},
+		{name: "other path", filter: store.SnapshotFilter{Paths: []string{"/home"}}},
+		{name: "any tag", filter: store.SnapshotFilter{Tags: []string{"weekly", "db"}}, expected: true},
+		{name: "other tag", filter: store.SnapshotFilter{Tags: []string{"weekly"}}},
+		{name: "since", filter: store.SnapshotFilter{Since: created}, expected: true},
+		{name: "after since", filter: store.SnapshotFilter{Since: created.Add(time.Second)}},
+		{name: "until", filter: store.SnapshotFilter{Until: created}, expected: true},
+		{name: "before until", filter: store.SnapshotFilter{Until: created.Add(-time.Second)}},
+		{
+			name:     "combined",
+			filter:   store.SnapshotFilter{Hosts: []string{"alpha"}, Tags: []string{"db"}, Since: created.AddDate(0, -1, 0), Until: created.AddDate(0, 1, 0)},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if match := tt.filter.Match(snap); match != tt.expected {
+				t.Fatalf("expected match %v, got %v", tt.expected, match)
+			}
+		})
+	}
+}
+
+func TestGroupSnapshots(t *testing.T) {
+	start := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	var snaps []storage.Snapshot
+	for i, host := range []string{"beta", "alpha", "beta", "alpha", "alpha"} {
+		snaps = append(snaps, storage.Snapshot{ID: string(rune('a' + i)), Hostname: host, BackupVolume: "/data", CreatedAt: start.Add(time.Duration(i) * time.Hour)})
+	}
+
+	groups := store.GroupSnapshots(snaps, store.GroupBy{Host: true})
+	if len(groups) != 2 || groups[0].Host != "alpha" || groups[1].Host != "beta" || groups[0].Path != "" {
+		t.Fatalf("expected groups for alpha and beta, got %+v", groups)
+	}
+
+	latest := groups[0].Latest(2)
+	var ids []string
+	for _, snap := range latest.Snapshots {
+		ids = append(ids, snap.ID)
+	}
+	if !slices.Equal(ids, []string{"d", "e"}) {
+		t.Fatalf("expected latest snapshots [d e], got %v", ids)
+	}
+
+	groups = store.GroupSnapshots(snaps, store.GroupBy{})
+	if len(groups) != 1 || len(groups[0].Snapshots) != len(snaps) {
+		t.Fatalf("expected a single group, got %+v", groups)
+	}
+}
+
+func TestFindSnapshots(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	dir := createBackupDir(t)
+	for _, tag := range []string{"first", "second"} {
+		err := s.Backup(ctx, dir, store.BackupOptions{Tags: []string{tag}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snaps, err := s.FindSnapshots(ctx, store.SnapshotFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 2 || snaps[0].Tags[0] != "first" || snaps[1].Tags[0] != "second" {
+		t.Fatalf("expected both snapshots oldest first, got %+v", snaps)
+	}
+
+	files, size := snaps[0].Stats()
+	if files != 3 || size != int64(len("hello, world")+len("warden ")*10000+64*1024) {
+		t.Fatalf("expected 3 files, got %d files of %d bytes", files, size)
+	}
+
+	snaps, err = s.FindSnapshots(ctx, store.SnapshotFilter{Tags: []string{"second"}, Paths: []string{dir}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 1 || snaps[0].Tags[0] != "second" {
+		t.Fatalf("expected only the second snapshot, got %+v", snaps)
+	}
+}
@@ -4,88 +4,142 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"path"
 
 	"github.com/julianstephens/warden/internal/backend"
 	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/chunker"
+	"github.com/julianstephens/warden/internal/compress"
 	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/storage"
 	"github.com/julianstephens/warden/internal/warden"
 )
 
+const chunkerSeedSize = 32
+
 type Store struct {
 	conf     warden.Config
+	secret   *warden.ConfigSecret
 	backend  common.Backend
 	master   *Key
+	index    *storage.Index
+	locking  lockState
 	Location string
+	// LockRetry is the backoff used while waiting for a locked store
+	LockRetry LockRetry
+	// LockRefresh controls how held locks are kept from going stale
+	LockRefresh LockRefresh
 }
 
-func NewStore(be common.Backend, loc string) *Store {
-	return &Store{backend: be, Location: loc}
+// InitOptions configures a new store
+type InitOptions struct {
+	Params      crypto.Params
+	Chunker     warden.ChunkerConfig
+	Compression warden.CompressionConfig
 }
 
-func OpenStore(ctx context.Context, storeLoc string) (*Store, error) {
-	// TODO: limit open attempts
-	warden.Log.Debug().Msg("==> store.OpenStore")
+func NewStore(be common.Backend, loc string) *Store {
+	return &Store{backend: be, Location: loc, index: storage.NewIndex(), LockRetry: DefaultLockRetry, LockRefresh: DefaultLockRefresh}
+}
 
+// OpenStore opens the local store at storeLoc with a password read from pwd
+func OpenStore(ctx context.Context, storeLoc string, pwd crypto.PasswordSource) (*Store, error) {
 	warden.Log.Debug().Msg("initializing backend...")
-	be, err := backend.NewBackend(common.LocalStorage, common.LocalStorageParams{Location: storeLoc})
+	be, err := backend.NewBackend(ctx, common.LocalStorage, common.LocalStorageParams{Location: storeLoc})
 	if err != nil {
 		return nil, fmt.Errorf("unable to initialize localstorage backend: %+v", err)
 	}
 	warden.Log.Debug().Msg("localstorage backend initialized.")
 
-	s := NewStore(be, storeLoc)
+	return Open(ctx, be, storeLoc, pwd)
+}
+
+// Open opens the store kept in be with a password read from pwd. loc
+// describes the store in logs and messages.
+//
+// Opening does not lock the store. Operations that change it take a lock,
+// waiting for conflicting locks as configured by the store's LockRetry.
+func Open(ctx context.Context, be common.Backend, loc string, pwd crypto.PasswordSource) (*Store, error) {
+	warden.Log.Debug().Msg("==> store.Open")
+
+	s := NewStore(be, loc)
 	warden.Log.Debug().Msg("store created.")
 
-	warden.Log.Debug().Msgf("attempting to open store at %s...", storeLoc)
-	err = s.open(ctx, storeLoc)
+	warden.Log.Debug().Msgf("attempting to open store at %s...", loc)
+	err := s.open(ctx, pwd)
 	if err != nil {
 		return nil, err
 	}
 	warden.Log.Debug().Msg("store opened.")
 
-	warden.Log.Debug().Msg("<== store.OpenStore")
+	warden.Log.Debug().Msg("<== store.Open")
 
 	return s, nil
 }
 
-func (s *Store) open(ctx context.Context, storeLoc string) (err error) {
+func (s *Store) open(ctx context.Context, pwd crypto.PasswordSource) (err error) {
 	warden.Log.Debug().Msg("reading store password...")
-	password, err := crypto.ReadPassword()
+	password, err := pwd.ReadPassword(false)
 	if err != nil {
 		return
 	}
 	warden.Log.Debug().Msg("password read.")
 
 	warden.Log.Debug().Msg("loading store config...")
-	config, err := warden.LoadJSON[warden.Config](path.Join(storeLoc, "config.json"))
+	configJson, err := s.backend.Load(ctx, common.Event{Type: common.Config}, 0, 0)
 	if err != nil {
-		return
+		return fmt.Errorf("unable to load store config: %w", err)
 	}
-	s.conf = config
-	warden.Log.Debug().Msg("config loaded.")
 
-	params, err := warden.MapToStruct[crypto.Params](config.Params)
+	var config warden.Config
+	err = json.Unmarshal(configJson, &config)
 	if err != nil {
-		return
+		return fmt.Errorf("unable to parse store config: %+v", err)
 	}
+	s.conf = config
+	warden.Log.Debug().Msg("config loaded.")
 
 	warden.Log.Debug().Msg("loading master key...")
-	master, err := LoadKey(ctx, s, storeLoc, params, password)
+	master, err := LoadKey(ctx, s, password)
 	if err != nil {
 		return
 	}
 	s.master = master
 	warden.Log.Debug().Msg("master key loaded.")
 
+	warden.Log.Debug().Msg("loading index...")
+	err = s.loadIndex(ctx)
+	if err != nil {
+		return
+	}
+	warden.Log.Debug().Msg("index loaded.")
+
 	return
 }
 
-func (s *Store) Init(ctx context.Context, params crypto.Params, password string) error {
+func (s *Store) Init(ctx context.Context, opts InitOptions, password string) error {
 	warden.Log.Debug().Msg("==> store.OpenStore")
 
+	err := chunkerOptions(opts.Chunker, nil).Validate()
+	if err != nil {
+		return fmt.Errorf("invalid chunker config: %+v", err)
+	}
+
+	if opts.Compression.Algorithm == "" {
+		opts.Compression = warden.CompressionConfig{Algorithm: compress.DefaultAlgorithm.String(), Level: compress.DefaultLevel}
+	}
+
+	algorithm, err := compress.ParseAlgorithm(opts.Compression.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	err = compress.ValidateLevel(algorithm, opts.Compression.Level)
+	if err != nil {
+		return err
+	}
+
 	warden.Log.Debug().Msg("creating store config...")
-	conf, err := warden.CreateConfig(params.ToMap())
+	conf, err := warden.CreateConfig(opts.Params.ToMap(), opts.Chunker, opts.Compression)
 	if err != nil {
 		return err
 	}
@@ -109,6 +163,24 @@ func (s *Store) init(ctx context.Context, password string, config warden.Config)
 	s.master = master
 	warden.Log.Debug().Msg("master key created.")
 
+	warden.Log.Debug().Msg("generating config secret...")
+	seed, err := crypto.NewRandom(chunkerSeedSize)
+	if err != nil {
+		return
+	}
+	s.secret = &warden.ConfigSecret{ChunkerSeed: seed}
+
+	secretJson, err := json.Marshal(s.secret)
+	if err != nil {
+		return
+	}
+
+	s.conf.Secret, err = crypto.Encrypt(*s.master.master, secretJson, nil)
+	if err != nil {
+		return
+	}
+	warden.Log.Debug().Msg("config secret generated.")
+
 	confJson, err := json.Marshal(&s.conf)
 	if err != nil {
 		return
@@ -124,6 +196,65 @@ func (s *Store) init(ctx context.Context, password string, config warden.Config)
 	return
 }
 
+// chunkerOptions returns the store's chunker settings, decrypting the config
+// secret on first use
+func (s *Store) chunkerOptions() (chunker.Options, error) {
+	if s.secret == nil && len(s.conf.Secret) > 0 {
+		secretJson, err := crypto.Decrypt(*s.master.master, s.conf.Secret, nil)
+		if err != nil {
+			return chunker.Options{}, fmt.Errorf("unable to decrypt config secret: %+v", err)
+		}
+
+		var secret warden.ConfigSecret
+		err = json.Unmarshal(secretJson, &secret)
+		if err != nil {
+			return chunker.Options{}, fmt.Errorf("unable to parse config secret: %+v", err)
+		}
+		s.secret = &secret
+	}
+
+	var seed []byte
+	if s.secret != nil {
+		seed = s.secret.ChunkerSeed
+	}
+
+	return chunkerOptions(s.conf.Chunker, seed), nil
+}
+
+func chunkerOptions(conf warden.ChunkerConfig, seed []byte) chunker.Options {
+	return chunker.Options{
+		MinSize:     conf.MinSize,
+		AverageSize: conf.AvgSize,
+		MaxSize:     conf.MaxSize,
+		Seed:        seed,
+	}
+}
+
+// newCompressor creates the compressor for a store's compression config.
+// Stores created without a compression config use the default algorithm.
+func newCompressor(conf warden.CompressionConfig) (*compress.Compressor, error) {
+	if conf.Algorithm == "" {
+		return compress.NewCompressor(compress.DefaultAlgorithm, compress.DefaultLevel)
+	}
+
+	algorithm, err := compress.ParseAlgorithm(conf.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	return compress.NewCompressor(algorithm, conf.Level)
+}
+
+// Close releases the store's lock, if any, and closes its backend
+func (s *Store) Close() error {
+	err := s.Unlock(context.Background())
+	if closeErr := s.backend.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
 func (s *Store) Key() *Key {
 	return s.master
 }
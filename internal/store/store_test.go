@@ -1,19 +1,26 @@
 package store_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"testing"
 	"time"
 
-	mp "github.com/agiledragon/gomonkey/v2"
 	"github.com/rs/zerolog"
 
 	"github.com/julianstephens/warden/internal/backend"
 	"github.com/julianstephens/warden/internal/backend/common"
+	"github.com/julianstephens/warden/internal/compress"
 	"github.com/julianstephens/warden/internal/crypto"
+	"github.com/julianstephens/warden/internal/exclude"
+	"github.com/julianstephens/warden/internal/storage"
 	"github.com/julianstephens/warden/internal/store"
 	"github.com/julianstephens/warden/internal/warden"
 )
@@ -33,25 +40,25 @@ func resetStore(t *testing.T) {
 
 func createAndInitStore(ctx context.Context, t *testing.T) *store.Store {
 	warden.SetLog(warden.NewLog(os.Stderr, zerolog.ErrorLevel, time.RFC1123))
-	be, err := backend.NewBackend(common.LocalStorage, common.LocalStorageParams{Location: testDir})
+	be, err := backend.NewBackend(ctx, common.LocalStorage, common.LocalStorageParams{Location: testDir})
 	if err != nil {
 		t.Fatal(err)
 	}
-	store := store.NewStore(be, testDir)
+	s := store.NewStore(be, testDir)
 
-	err = store.Init(ctx, crypto.DefaultParams, testPwd)
+	err = s.Init(ctx, store.InitOptions{Params: crypto.DefaultParams}, testPwd)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	return store
+	return s
 }
 
 func TestInit(t *testing.T) {
 	resetStore(t)
 
 	ctx := context.Background()
-	createAndInitStore(ctx, t)
+	original := createAndInitStore(ctx, t)
 
 	conf, err := warden.LoadJSON[warden.Config](path.Join(testDir, "config.json"))
 	if err != nil {
@@ -66,6 +73,14 @@ func TestInit(t *testing.T) {
 		t.Fatal("expected config params, got nil")
 	}
 
+	if len(conf.Secret) == 0 {
+		t.Fatal("expected encrypted config secret, got none")
+	}
+
+	if _, err := crypto.Decrypt(*original.Key().Decrypt(), conf.Secret, nil); err != nil {
+		t.Fatalf("expected config secret encrypted with master key, got err: %+v", err)
+	}
+
 	if _, err := os.Stat(path.Join(testDir, "keys")); os.IsNotExist(err) {
 		t.Fatal("key directory not found")
 	}
@@ -76,16 +91,37 @@ func TestInit(t *testing.T) {
 	}
 }
 
+func TestInitInvalidCompressionLevel(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	be, err := backend.NewBackend(ctx, common.LocalStorage, common.LocalStorageParams{Location: testDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := store.NewStore(be, testDir)
+
+	opts := store.InitOptions{
+		Params:      crypto.DefaultParams,
+		Compression: warden.CompressionConfig{Algorithm: compress.Zstd.String(), Level: 30},
+	}
+	err = s.Init(ctx, opts, testPwd)
+	if !errors.Is(err, compress.ErrInvalidLevel) {
+		t.Fatalf("expected error %+v, got %+v", compress.ErrInvalidLevel, err)
+	}
+
+	if _, err := os.Stat(path.Join(testDir, "config.json")); !os.IsNotExist(err) {
+		t.Fatalf("expected no config after rejected init, got err: %+v", err)
+	}
+}
+
 func TestOpen(t *testing.T) {
 	resetStore(t)
 
 	ctx := context.Background()
 	original := createAndInitStore(ctx, t)
 
-	patches := mp.ApplyFuncReturn(crypto.ReadPassword, testPwd, nil)
-	defer patches.Reset()
-
-	opened, err := store.OpenStore(ctx, testDir)
+	opened, err := store.OpenStore(ctx, testDir, crypto.StaticPassword(testPwd))
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -105,12 +141,89 @@ func TestOpen(t *testing.T) {
 		t.Fatalf("expected user %s, got %s", originalKey.Username, openedKey.Username)
 	}
 
-	if !openedKey.CreatedAt.After(originalKey.CreatedAt) {
-		t.Fatalf("expected new key creation stamp after original: %s, %s", openedKey.CreatedAt, originalKey.CreatedAt)
+	if !openedKey.CreatedAt.Equal(originalKey.CreatedAt) {
+		t.Fatalf("expected key creation stamp %s, got %s", originalKey.CreatedAt, openedKey.CreatedAt)
+	}
+
+	if openedKey.ID() != originalKey.ID() {
+		t.Fatalf("expected key id %s, got %s", originalKey.ID(), openedKey.ID())
+	}
+
+	if !bytes.Equal(original.Key().Decrypt().Data, opened.Key().Decrypt().Data) {
+		t.Fatalf("expected decrypted key %x, got %x", original.Key().Decrypt().Data, opened.Key().Decrypt().Data)
+	}
+}
+
+func TestOpenWrongPassword(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	createAndInitStore(ctx, t)
+
+	_, err := store.OpenStore(ctx, testDir, crypto.StaticPassword("notthestorepassword789"))
+	var pwdErr *warden.InvalidPasswordError
+	if !errors.As(err, &pwdErr) {
+		t.Fatalf("expected invalid password error, got %v", err)
+	}
+}
+
+func TestOpenAcrossSessions(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	original := createAndInitStore(ctx, t)
+
+	otherPwd := "anothersecurepassword456"
+	_, err := original.AddPassword(ctx, otherPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backupDir := createBackupDir(t)
+	err = original.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	if string(original.Key().Decrypt().Data) == string(opened.Key().Decrypt().Data) {
-		t.Fatalf("expected decrypted key %s, got %s", string(original.Key().Decrypt().Data), string(opened.Key().Decrypt().Data))
+	for _, pwd := range []string{testPwd, otherPwd} {
+		opened, err := store.OpenStore(ctx, testDir, crypto.StaticPassword(pwd))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		target := t.TempDir()
+		err = opened.Restore(ctx, "latest", target, store.RestoreOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, p := range listFiles(t, backupDir) {
+			rel, _ := filepath.Rel(backupDir, p)
+			originalData, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restored, err := os.ReadFile(path.Join(target, rel))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(originalData, restored) {
+				t.Fatalf("restored %s does not match original", rel)
+			}
+		}
+
+		// a second backup in the new session must deduplicate against the first
+		err = opened.Backup(ctx, backupDir, store.BackupOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packs := listFiles(t, path.Join(testDir, "packs"))
+	if len(packs) != 1 {
+		t.Fatalf("expected 1 pack after unchanged backups, got %d", len(packs))
 	}
 }
 
@@ -141,26 +254,547 @@ func TestKey(t *testing.T) {
 	}
 }
 
-func TestBackup(t *testing.T) {
-	warden.SetLog(warden.NewLog(os.Stderr, zerolog.ErrorLevel, time.RFC1123))
+func TestKeyManagement(t *testing.T) {
+	resetStore(t)
 
 	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+	current := s.Key().ID()
 
-	patches := mp.ApplyFuncReturn(crypto.ReadPassword, testPwd, nil)
-	defer patches.Reset()
+	otherPwd := "anothersecurepassword456"
+	added, err := s.AddPassword(ctx, otherPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	s, err := store.OpenStore(ctx, testDir)
+	// the new key must wrap the same master key
+	opened, err := store.OpenStore(ctx, testDir, crypto.StaticPassword(otherPwd))
 	if err != nil {
 		t.Fatal(err)
 	}
+	if opened.Key().ID() != added.ID() || !bytes.Equal(opened.Key().Decrypt().Data, s.Key().Decrypt().Data) {
+		t.Fatal("expected added key to wrap the store master key")
+	}
 
-	err = s.Backup(ctx, testDir)
+	keys, err := s.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+
+	err = s.RemoveKey(ctx, current.String()[:8])
+	if !errors.Is(err, store.ErrKeyInUse) {
+		t.Fatalf("expected %v, got %v", store.ErrKeyInUse, err)
+	}
+
+	err = s.RemoveKey(ctx, "ffffffffffff")
+	if !errors.Is(err, store.ErrKeyNotFound) {
+		t.Fatalf("expected %v, got %v", store.ErrKeyNotFound, err)
+	}
+
+	err = s.RemoveKey(ctx, added.ID().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.RemoveKey(ctx, current.String())
+	if !errors.Is(err, store.ErrLastKey) {
+		t.Fatalf("expected %v, got %v", store.ErrLastKey, err)
+	}
+
+	changed, err := s.ChangePassword(ctx, otherPwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err = s.Keys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].ID() != changed.ID() || s.Key().ID() != changed.ID() {
+		t.Fatalf("expected only key %s after password change, got %+v", changed.ID(), keys)
+	}
+}
+
+func createBackupDir(t *testing.T) string {
+	dir := t.TempDir()
+
+	files := map[string][]byte{
+		"a.txt":          []byte("hello, world"),
+		"nested/b.txt":   bytes.Repeat([]byte("warden "), 10000),
+		"nested/c/d.bin": make([]byte, 64*1024),
+	}
+	for name, data := range files {
+		p := path.Join(dir, name)
+		if err := warden.EnsureDir(path.Dir(p)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func listFiles(t *testing.T, dir string) []string {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestBackup(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	err := s.Backup(ctx, testDir, store.BackupOptions{})
 	if err == nil {
 		t.Fatal("should error on backup dir equals warden store dir")
 	}
 
-	err = s.Backup(ctx, "/home/julian/workspace/notes")
+	err = s.Backup(ctx, createBackupDir(t), store.BackupOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
+
+	packs := listFiles(t, path.Join(testDir, "packs"))
+	if len(packs) != 1 {
+		t.Fatalf("expected 1 pack, got %d", len(packs))
+	}
+
+	data, err := os.ReadFile(packs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := crypto.Hash(data).String(); id != path.Base(packs[0]) {
+		t.Fatalf("expected pack name to match content hash %s, got %s", id, path.Base(packs[0]))
+	}
+
+	header, err := storage.ReadHeader(*s.Key().Decrypt(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(header.Blobs) == 0 {
+		t.Fatal("expected pack header to list blobs")
+	}
+
+	compressed := false
+	for _, b := range header.Blobs {
+		if b.Type == storage.CompressedData {
+			compressed = true
+		}
+	}
+	if !compressed {
+		t.Fatal("expected compressible chunks to be stored compressed")
+	}
+}
+
+func TestBackupDeduplication(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := createBackupDir(t)
+	err := s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if indexes := listFiles(t, path.Join(testDir, "index")); len(indexes) != 1 {
+		t.Fatalf("expected 1 index file, got %d", len(indexes))
+	}
+
+	otherDir := t.TempDir()
+	data, err := os.ReadFile(path.Join(backupDir, "nested", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path.Join(otherDir, "copy.txt"), data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{backupDir, otherDir} {
+		err = s.Backup(ctx, dir, store.BackupOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if packs := listFiles(t, path.Join(testDir, "packs")); len(packs) != 1 {
+		t.Fatalf("expected known chunks to be skipped, got %d packs", len(packs))
+	}
+
+	if indexes := listFiles(t, path.Join(testDir, "index")); len(indexes) != 1 {
+		t.Fatalf("expected no new index files, got %d", len(indexes))
+	}
+}
+
+func TestBackupSnapshots(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := createBackupDir(t)
+	err := s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snaps) != 1 {
+		t.Fatalf("expected 1 snapshot, got %d", len(snaps))
+	}
+
+	first := snaps[0]
+	if first.Parent != "" {
+		t.Fatalf("expected first snapshot to have no parent, got %s", first.Parent)
+	}
+
+	if len(first.Paths) != 5 {
+		t.Fatalf("expected 5 paths, got %d", len(first.Paths))
+	}
+
+	if first.Paths[0].Path != "a.txt" || first.Paths[0].FilePerm != "0644" || len(first.Paths[0].Chunks) != 1 {
+		t.Fatalf("unexpected path metadata: %+v", first.Paths[0])
+	}
+
+	data, err := os.ReadFile(path.Join(testDir, "snapshots", first.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if id := crypto.Hash(data).String(); id != first.ID {
+		t.Fatalf("expected snapshot id to match content hash %s, got %s", id, first.ID)
+	}
+
+	if bytes.Contains(data, []byte("nested/b.txt")) || bytes.Contains(data, []byte(`"hostname"`)) {
+		t.Fatal("expected snapshot to be encrypted")
+	}
+
+	err = os.WriteFile(path.Join(backupDir, "a.txt"), []byte("goodbye, world"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snaps, err = s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snaps) != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", len(snaps))
+	}
+
+	second := snaps[0]
+	if second.ID == first.ID {
+		second = snaps[1]
+	}
+
+	if second.Parent != first.ID {
+		t.Fatalf("expected parent %s, got %s", first.ID, second.Parent)
+	}
+
+	for i, p := range second.Paths {
+		changed := slices.Compare(p.Chunks, first.Paths[i].Chunks) != 0
+		if changed != (p.Path == "a.txt") {
+			t.Fatalf("unexpected chunk list for %s: %+v", p.Path, p.Chunks)
+		}
+	}
+}
+
+func TestBackupPipeline(t *testing.T) {
+	ctx := context.Background()
+	backupDir := createBackupDir(t)
+
+	tests := []struct {
+		name string
+		opts store.BackupOptions
+	}{
+		{"single worker", store.BackupOptions{FileWorkers: 1, BlobWorkers: 1, Uploaders: 1}},
+		{"many workers", store.BackupOptions{FileWorkers: 4, BlobWorkers: 8, Uploaders: 4}},
+		{"small memory limit", store.BackupOptions{FileWorkers: 4, BlobWorkers: 4, Uploaders: 2, MaxMemory: 1024}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetStore(t)
+			s := createAndInitStore(ctx, t)
+
+			err := s.Backup(ctx, backupDir, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			target := t.TempDir()
+			err = s.Restore(ctx, "latest", target, store.RestoreOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, p := range listFiles(t, backupDir) {
+				rel, _ := filepath.Rel(backupDir, p)
+				original, err := os.ReadFile(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				restored, err := os.ReadFile(path.Join(target, rel))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !bytes.Equal(original, restored) {
+					t.Fatalf("restored %s does not match original", rel)
+				}
+			}
+		})
+	}
+}
+
+func TestBackupReader(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := createBackupDir(t)
+	data, err := os.ReadFile(path.Join(backupDir, "nested", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	packs := listFiles(t, path.Join(testDir, "packs"))
+
+	// the streamed data matches a file already stored
+	err = s.BackupReader(ctx, bytes.NewReader(data), "db.sql", store.BackupOptions{Tags: []string{"stdin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after := listFiles(t, path.Join(testDir, "packs")); len(after) != len(packs) {
+		t.Fatalf("expected streamed data to be deduplicated, got %d packs", len(after))
+	}
+
+	snap, err := s.FindSnapshot(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.BackupVolume != store.StdinPrefix+"db.sql" || len(snap.Paths) != 1 || snap.Paths[0].Path != "db.sql" || snap.Paths[0].FileSize != int64(len(data)) {
+		t.Fatalf("expected a snapshot of db.sql, got %+v", snap)
+	}
+
+	target := t.TempDir()
+	err = s.Restore(ctx, "latest", target, store.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := os.ReadFile(path.Join(target, "db.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, data) {
+		t.Fatal("restored db.sql does not match the streamed data")
+	}
+
+	for _, name := range []string{"", "..", "dir/db.sql"} {
+		err = s.BackupReader(ctx, bytes.NewReader(data), name, store.BackupOptions{})
+		if !errors.Is(err, store.ErrInvalidFileName) {
+			t.Fatalf("expected %v for %q, got %v", store.ErrInvalidFileName, name, err)
+		}
+	}
+}
+
+func TestBackupCancelled(t *testing.T) {
+	resetStore(t)
+
+	s := createAndInitStore(context.Background(), t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.Backup(ctx, createBackupDir(t), store.BackupOptions{})
+	if err == nil {
+		t.Fatal("expected cancelled backup to fail")
+	}
+
+	snaps, err := s.ListSnapshots(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snaps) != 0 {
+		t.Fatalf("expected no snapshots, got %d", len(snaps))
+	}
+}
+
+func TestBackupExclude(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	err := s.Backup(ctx, createBackupDir(t), store.BackupOptions{
+		Exclude: exclude.Options{Patterns: []string{"*.bin", "/a.txt"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snap, err := s.FindSnapshot(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := warden.Filter(snap.Paths, func(p storage.PathMetadata) bool {
+		return p.IsFile()
+	})
+	if len(files) != 1 || files[0].Path != "nested/b.txt" {
+		t.Fatalf("expected only nested/b.txt to be backed up, got %+v", files)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	backupDir := createBackupDir(t)
+	err := os.Chmod(path.Join(backupDir, "a.txt"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	err = os.Chtimes(path.Join(backupDir, "nested", "b.txt"), mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.Backup(ctx, backupDir, store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := t.TempDir()
+	err = s.Restore(ctx, "latest", target, store.RestoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range listFiles(t, backupDir) {
+		rel, _ := filepath.Rel(backupDir, p)
+		original, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		restored, err := os.ReadFile(path.Join(target, rel))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(original, restored) {
+			t.Fatalf("restored %s does not match original", rel)
+		}
+	}
+
+	info, err := os.Stat(path.Join(target, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("expected permission %s, got %s", os.FileMode(0600), info.Mode().Perm())
+	}
+
+	info, err = os.Stat(path.Join(target, "nested", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Fatalf("expected mtime %s, got %s", mtime, info.ModTime())
+	}
+
+	snaps, err := s.ListSnapshots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partial := t.TempDir()
+	err = s.Restore(ctx, snaps[0].ID[:8], partial, store.RestoreOptions{Include: []string{"nested"}, Exclude: []string{"*.bin"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := listFiles(t, partial)
+	if len(restored) != 1 || restored[0] != path.Join(partial, "nested", "b.txt") {
+		t.Fatalf("expected only nested/b.txt to be restored, got %+v", restored)
+	}
+}
+
+func TestRestoreCorruptPack(t *testing.T) {
+	resetStore(t)
+
+	ctx := context.Background()
+	s := createAndInitStore(ctx, t)
+
+	err := s.Backup(ctx, createBackupDir(t), store.BackupOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range listFiles(t, path.Join(testDir, "packs")) {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[0] ^= 0xff
+
+		err = os.Chmod(p, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(p, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = s.Restore(ctx, "latest", t.TempDir(), store.RestoreOptions{})
+	if !errors.Is(err, store.ErrChunkIntegrity) {
+		t.Fatalf("expected error %+v, got %+v", store.ErrChunkIntegrity, err)
+	}
 }
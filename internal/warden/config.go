@@ -1,15 +1,39 @@
 package warden
 
 type Config struct {
-	ID     string         `json:"id"`
-	Params map[string]int `json:"params"`
+	ID          string            `json:"id"`
+	Params      map[string]int    `json:"params"`
+	Chunker     ChunkerConfig     `json:"chunker"`
+	Compression CompressionConfig `json:"compression"`
+	// Secret holds the encrypted ConfigSecret
+	Secret []byte `json:"secret,omitempty"`
 }
 
-func CreateConfig(params map[string]int) (Config, error) {
+// ChunkerConfig holds the chunk sizes of a store in bytes
+type ChunkerConfig struct {
+	MinSize int `json:"minSize"`
+	AvgSize int `json:"avgSize"`
+	MaxSize int `json:"maxSize"`
+}
+
+// CompressionConfig holds the compression algorithm name and level of a store
+type CompressionConfig struct {
+	Algorithm string `json:"algorithm"`
+	Level     int    `json:"level"`
+}
+
+// ConfigSecret holds config values that must never be stored in plaintext
+type ConfigSecret struct {
+	ChunkerSeed []byte `json:"chunkerSeed"`
+}
+
+func CreateConfig(params map[string]int, chunker ChunkerConfig, compression CompressionConfig) (Config, error) {
 	var conf Config
 
 	conf.ID = NewID().String()
 	conf.Params = params
+	conf.Chunker = chunker
+	conf.Compression = compression
 
 	return conf, nil
 }